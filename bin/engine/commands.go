@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Kozical/taskengine/core/engine"
+	"github.com/Kozical/taskengine/core/plugin"
+	"github.com/Kozical/taskengine/providers"
+)
+
+// Validate implements `taskengine validate`, checking every job file against
+// the provider schemas. It returns the process exit code so it can gate CI.
+func Validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	jobsPath := fs.String("jobs", "jobs", "specify the directory containing the job files to validate")
+	pluginsPath := fs.String("plugins", "", "specify a directory of provider plugin executables whose schemas are checked as well")
+	fs.Parse(args)
+
+	files, err := engine.JobFiles(*jobsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list job files -> %v\n", err)
+		return 1
+	}
+	schemas := providers.Schemas()
+	if *pluginsPath != "" {
+		plugins, errs := plugin.Discover(*pluginsPath)
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Failed to load plugin -> %v\n", e)
+		}
+		for _, p := range plugins {
+			schemas[p.Info.Name] = p.Info.Schema
+			for _, alias := range p.Info.Aliases {
+				schemas[alias] = p.Info.Schema
+			}
+			p.Close()
+		}
+	}
+
+	var failed int
+	for _, f := range files {
+		jobs, err := engine.LoadFile(f, *jobsPath)
+		if err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		var errs []error
+		for _, job := range jobs {
+			errs = append(errs, engine.Validate(job, schemas)...)
+		}
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d job files failed validation\n", failed, len(files))
+		return 1
+	}
+	fmt.Printf("%d job files ok\n", len(files))
+	return 0
+}
+
+// Fmt implements `taskengine fmt`, rewriting job files in canonical form,
+// imported fragments included. With -check files are left untouched and those
+// that need formatting are listed.
+func Fmt(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	jobsPath := fs.String("jobs", "jobs", "specify the directory containing the job files to format")
+	check := fs.Bool("check", false, "report job files that are not formatted instead of rewriting them")
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		var err error
+		files, err = engine.SourceFiles(*jobsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list job files -> %v\n", err)
+			return 1
+		}
+	}
+
+	var status int
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		out, err := engine.FormatSource(engine.JobName(f, *jobsPath), src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		if bytes.Equal(src, out) {
+			continue
+		}
+		if *check {
+			fmt.Println(f)
+			status = 1
+			continue
+		}
+		if err = ioutil.WriteFile(f, out, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+	return status
+}
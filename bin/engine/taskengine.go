@@ -10,7 +10,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/Kozical/taskengine/core/engine"
@@ -23,6 +25,8 @@ import (
 	}
 */
 
+var reload = flag.Duration("reload", 5*time.Second, "specify how often the jobs directory is checked for changed job files, 0 disables reloading [default: 5s]")
+
 func init() {
 	logPath := flag.String("logpath", "", "specify a directory for log output, if not specified logs will be written to Stdout")
 	flag.Parse()
@@ -41,6 +45,13 @@ func init() {
 	log.SetOutput(f)
 }
 func main() {
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(Validate(flag.Args()[1:]))
+	case "fmt":
+		os.Exit(Fmt(flag.Args()[1:]))
+	}
+
 	tlsConfig, err := ReadConfiguration()
 	if err != nil {
 		panic(err)
@@ -51,9 +62,14 @@ func main() {
 	mgr := engine.NewRPCMgr(5, endpoints, tlsConfig)
 	defer mgr.Cleanup()
 
-	jobs, err := engine.ParseJobsInDirectory("jobs")
-	if err != nil {
-		panic(err)
+	watcher := engine.NewWatcher("jobs", *reload)
+	jobs, err := watcher.Load()
+	if errs, ok := err.(engine.ErrorList); ok {
+		for _, e := range errs {
+			log.Printf("Failed to load job -> %v\n", e)
+		}
+	} else if err != nil {
+		log.Fatalf("Failed to load jobs -> %v\n", err)
 	}
 
 	//Implement Job queuing and job assignment
@@ -62,15 +78,21 @@ func main() {
 	//will need to implement better 'watching' routine
 	//so that we can identify when a 'dead' node comes back
 	//online and throw him back into the rotation
-	err = mgr.DispatchJobs(jobs)
-	if err != nil {
-		panic(err)
-	}
+	// jobs that fail to dispatch are dispatched again by the watcher
+	watcher.Applied(mgr.ApplyChanges(engine.JobChanges{Added: jobs}))
 
 	//t.AssignRunners()
 	//t.DispatchJobs()
 
-	fmt.Scanln()
+	if *reload > 0 {
+		go watcher.Watch(mgr.ApplyChanges)
+		defer watcher.Close()
+	}
+
+	intC := make(chan os.Signal, 1)
+	signal.Notify(intC, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Printf("Received %s signal..\n", <-intC)
 }
 
 func ReadConfiguration() (tlsConfig *tls.Config, err error) {
@@ -15,12 +15,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Kozical/taskengine/core/plugin"
 	"github.com/Kozical/taskengine/core/runner"
-
-	"github.com/Kozical/taskengine/providers/listener"
-	"github.com/Kozical/taskengine/providers/localexec"
-	"github.com/Kozical/taskengine/providers/mongo"
-	"github.com/Kozical/taskengine/providers/ticker"
+	"github.com/Kozical/taskengine/providers"
 )
 
 func main() {
@@ -28,17 +25,34 @@ func main() {
 	port := flag.Int("port", 8103, "specify the port that should be used for this runner [default: 8103]")
 	listenerPath := flag.String("listener", "config/listener.json", "specify the path to the listener config [default: config/listener.json]")
 	mongoPath := flag.String("mongo", "config/mongo.json", "specify the path to the mongo config [default: config/mongo.json]")
+	pluginsPath := flag.String("plugins", "plugins", "specify a directory of provider plugin executables [default: plugins]")
+	secretsPath := flag.String("secrets", "", "specify a directory holding one file per secret, if not specified secrets are read from TASKENGINE_SECRET_<NAME> environment variables")
 
 	flag.Parse()
 
 	ConfigureLogging(*logPath)
 
 	t := new(runner.Runner)
+	if *secretsPath != "" {
+		t.Secrets = runner.FileSecretStore{Dir: *secretsPath}
+	} else {
+		t.Secrets = runner.EnvSecretStore{Prefix: "TASKENGINE_SECRET_"}
+	}
 
-	err := RegisterProviders(t, *mongoPath, *listenerPath)
+	err := providers.Register(t, providers.Config{
+		ListenerPath: *listenerPath,
+		MongoPath:    *mongoPath,
+	})
 	if err != nil {
 		panic(err)
 	}
+	plugins, errs := plugin.Register(t, *pluginsPath)
+	for _, e := range errs {
+		log.Printf("Failed to load plugin -> %v\n", e)
+	}
+	for _, p := range t.Providers() {
+		log.Printf("Registered provider %s %s %v\n", p.Name, p.Version, p.Aliases)
+	}
 
 	srv, err := runner.NewRPCServer(&runner.RPCTask{
 		T: t,
@@ -60,6 +74,9 @@ func main() {
 	log.Printf("Received %s signal..\n", <-intC)
 
 	srv.Close()
+	for _, p := range plugins {
+		p.Close()
+	}
 }
 
 func ConfigureLogging(logPath string) {
@@ -112,29 +129,3 @@ func ReadConfiguration() (tlsConfig *tls.Config, err error) {
 	}
 	return
 }
-
-func RegisterProviders(r *runner.Runner, mongoPath, listenerPath string) (err error) {
-	var lp, mp runner.Provider
-
-	if _, err = os.Stat(listenerPath); err == nil {
-		lp, err = listener.NewListenerProvider("config/listener.json")
-		if err != nil {
-			return
-		}
-		r.RegisterProviders(lp)
-	}
-
-	if _, err = os.Stat(mongoPath); err == nil {
-		mp, err = mongo.NewMongoProvider(mongoPath)
-		if err != nil {
-			return
-		}
-		r.RegisterProviders(mp)
-	}
-
-	r.RegisterProviders(
-		ticker.NewTickerProvider(),
-		localexec.NewLocalExecProvider(),
-	)
-	return
-}
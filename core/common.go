@@ -1,16 +1,107 @@
 package core
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 )
 
 type ParseObject struct {
 	Provider   string          `json:"provider"`
 	Name       string          `json:"name"`
 	Properties json.RawMessage `json:"properties"`
+	Options    TaskOptions     `json:"options"`
+}
+
+// State keys of the error that failed a job, set for its on_failure and
+// finally tasks.
+const (
+	ErrorMessageOutput = "error.Message"
+	ErrorTaskOutput    = "error.Task"
+)
+
+// TaskOptions are the lower case properties of a resource, they are handled
+// by the runner instead of being passed to the provider.
+type TaskOptions struct {
+	// Timeout cancels the task when it runs for longer, zero means no limit
+	Timeout Duration `json:"timeout"`
+	// Retry runs the task again when it fails, nil means it is not retried
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// DependsOn names the tasks that must finish before this one in a
+	// parallel job
+	DependsOn []String `json:"depends_on,omitempty"`
+	// After holds the indices of the tasks a task of a parallel job waits
+	// for, set by the engine from DependsOn and the tasks its $(Task.Output)
+	// references point to
+	After []int `json:"after,omitempty"`
+	// When skips the task unless it holds, see Condition
+	When String `json:"when,omitempty"`
+	// ForEach runs the task once for every item of an array, at most
+	// Concurrency of them at a time, see ForEachItem and ForEachIndex
+	ForEach     ForEach `json:"foreach,omitempty"`
+	Concurrency Int     `json:"concurrency,omitempty"`
+}
+
+// State keys of the item and the index of the current iteration of a
+// foreach task, fields of the item are available as $(item.field).
+const (
+	ForEachItem  = "item"
+	ForEachIndex = "index"
+)
+
+// Check reports options the runner could not honour.
+func (o TaskOptions) Check() error {
+	if len(o.When) > 0 {
+		if _, err := ParseCondition(o.When.String()); err != nil {
+			return err
+		}
+	}
+	if err := o.ForEach.Check(); err != nil {
+		return err
+	}
+	if o.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if o.Concurrency > 0 && len(o.ForEach) == 0 {
+		return fmt.Errorf("concurrency requires foreach")
+	}
+	if o.Retry != nil {
+		return o.Retry.Check()
+	}
+	return nil
+}
+
+// JobOptions are the properties of the job { } block of a job file.
+type JobOptions struct {
+	// Timeout cancels a run of the job when it takes longer, zero means no
+	// limit
+	Timeout Duration `json:"timeout"`
+	// Parallel runs the tasks of the job as a dependency graph, see
+	// TaskOptions.After, instead of one after the other
+	Parallel bool `json:"parallel"`
 }
 
 type RPCJob struct {
 	Name    string
 	Objects []ParseObject
+	Options JobOptions
+	// OnFailure runs when a task of Objects failed, Finally after every run
+	OnFailure []ParseObject
+	Finally   []ParseObject
+	// Hash identifies the content of the job, see HashJob
+	Hash string
+}
+
+// HashJob returns a hash of the name and objects of job, jobs with the same
+// hash do not need to be dispatched again.
+func HashJob(job *RPCJob) string {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	enc.Encode(job.Name)
+	enc.Encode(job.Objects)
+	enc.Encode(job.Options)
+	enc.Encode(job.OnFailure)
+	enc.Encode(job.Finally)
+	return hex.EncodeToString(h.Sum(nil))
 }
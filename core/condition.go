@@ -0,0 +1,326 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is the when option of a task, the task is skipped when it is
+// false:
+//
+//	when: $(ListenForConnections.Method) == POST && $(Latest.Result)
+//
+// Operands are $(Task.Output) and ${scheme:name} references, quoted strings
+// and bare words. == and != compare text, <, <=, > and >= compare numbers
+// when both sides are numbers and text otherwise. !, && and || combine
+// conditions and parentheses group them. An operand on its own is true
+// unless it is empty, false, 0, null, [] or {}, so an empty mongo Result is
+// false. References that cannot be resolved are empty.
+type Condition struct {
+	Text string
+	root condNode
+}
+
+// ParseCondition parses the text of a when option.
+func ParseCondition(text string) (*Condition, error) {
+	toks, err := tokenizeCondition(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %v", text, err)
+	}
+	p := &condParser{toks: toks}
+	root, err := p.or()
+	if err == nil && p.i < len(p.toks) {
+		err = fmt.Errorf("unexpected %s", p.toks[p.i].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %v", text, err)
+	}
+	return &Condition{Text: text, root: root}, nil
+}
+
+// Eval evaluates c, lookup returns the value of a reference given with its
+// $(...) or ${...} delimiters.
+func (c *Condition) Eval(lookup func(ref string) (string, bool)) bool {
+	return Truthy(c.root.value(lookup))
+}
+
+// Truthy reports whether s counts as true in a condition.
+func Truthy(s string) bool {
+	switch strings.TrimSpace(s) {
+	case "", "false", "0", "null", "[]", "{}":
+		return false
+	}
+	return true
+}
+
+type condTokenType int
+
+const (
+	condOperand condTokenType = iota
+	condReference
+	condOperator
+	condLeftParen
+	condRightParen
+)
+
+type condToken struct {
+	typ  condTokenType
+	text string
+}
+
+// condOperators are matched longest first.
+var condOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+func tokenizeCondition(text string) (toks []condToken, err error) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(text[i:], "$(") || strings.HasPrefix(text[i:], "${"):
+			var n int
+			if text[i+1] == '(' {
+				_, n = ScanExpression(text[i:], false)
+			} else if n = strings.IndexByte(text[i:], '}'); n >= 0 {
+				n++
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated reference %s", text[i:])
+			}
+			toks = append(toks, condToken{condReference, text[i : i+n]})
+			i += n
+		case c == '(':
+			toks = append(toks, condToken{condLeftParen, "("})
+			i++
+		case c == ')':
+			toks = append(toks, condToken{condRightParen, ")"})
+			i++
+		case c == '"':
+			n := i + 1
+			for ; n < len(text) && text[n] != '"'; n++ {
+				if text[n] == '\\' {
+					n++
+				}
+			}
+			if n >= len(text) {
+				return nil, fmt.Errorf("unterminated string %s", text[i:])
+			}
+			s, err := strconv.Unquote(text[i : n+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", text[i:n+1])
+			}
+			toks = append(toks, condToken{condOperand, s})
+			i = n + 1
+		case c == '\'':
+			n := strings.IndexByte(text[i+1:], '\'')
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated string %s", text[i:])
+			}
+			toks = append(toks, condToken{condOperand, text[i+1 : i+1+n]})
+			i += n + 2
+		default:
+			if op := matchOperator(text[i:]); len(op) > 0 {
+				toks = append(toks, condToken{condOperator, op})
+				i += len(op)
+				continue
+			}
+			n := i
+			for n < len(text) && !unicode.IsSpace(rune(text[n])) && !strings.ContainsRune("()=!<>&|\"'", rune(text[n])) {
+				n++
+			}
+			if n == i {
+				return nil, fmt.Errorf("unexpected %q", text[i])
+			}
+			toks = append(toks, condToken{condOperand, text[i:n]})
+			i = n
+		}
+	}
+	return
+}
+
+func matchOperator(s string) string {
+	for _, op := range condOperators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// condNode is a node of a parsed condition, conditions evaluate to "true"
+// or "false".
+type condNode interface {
+	value(lookup func(string) (string, bool)) string
+}
+
+type condLiteral string
+
+func (n condLiteral) value(lookup func(string) (string, bool)) string {
+	return string(n)
+}
+
+type condRef string
+
+func (n condRef) value(lookup func(string) (string, bool)) string {
+	v, _ := lookup(string(n))
+	return v
+}
+
+type condNot struct {
+	x condNode
+}
+
+func (n condNot) value(lookup func(string) (string, bool)) string {
+	return strconv.FormatBool(!Truthy(n.x.value(lookup)))
+}
+
+type condBinary struct {
+	op   string
+	x, y condNode
+}
+
+func (n condBinary) value(lookup func(string) (string, bool)) string {
+	switch n.op {
+	// && and || only evaluate y when x does not decide the result
+	case "&&":
+		return strconv.FormatBool(Truthy(n.x.value(lookup)) && Truthy(n.y.value(lookup)))
+	case "||":
+		return strconv.FormatBool(Truthy(n.x.value(lookup)) || Truthy(n.y.value(lookup)))
+	}
+	a, b := n.x.value(lookup), n.y.value(lookup)
+	var cmp int
+	fa, erra := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	fb, errb := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	switch {
+	case erra == nil && errb == nil && fa < fb:
+		cmp = -1
+	case erra == nil && errb == nil && fa > fb:
+		cmp = 1
+	case erra == nil && errb == nil:
+	default:
+		cmp = strings.Compare(a, b)
+	}
+	switch n.op {
+	case "==":
+		return strconv.FormatBool(cmp == 0)
+	case "!=":
+		return strconv.FormatBool(cmp != 0)
+	case "<":
+		return strconv.FormatBool(cmp < 0)
+	case "<=":
+		return strconv.FormatBool(cmp <= 0)
+	case ">":
+		return strconv.FormatBool(cmp > 0)
+	default:
+		return strconv.FormatBool(cmp >= 0)
+	}
+}
+
+// condParser is a recursive descent parser, from the loosest binding
+// operator to the tightest: ||, &&, !, comparisons and operands.
+type condParser struct {
+	toks []condToken
+	i    int
+}
+
+func (p *condParser) peek() (condToken, bool) {
+	if p.i >= len(p.toks) {
+		return condToken{}, false
+	}
+	return p.toks[p.i], true
+}
+
+func (p *condParser) isOperator(ops ...string) (string, bool) {
+	t, ok := p.peek()
+	if !ok || t.typ != condOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *condParser) or() (condNode, error) {
+	x, err := p.and()
+	for err == nil {
+		if _, ok := p.isOperator("||"); !ok {
+			break
+		}
+		p.i++
+		var y condNode
+		if y, err = p.and(); err == nil {
+			x = condBinary{"||", x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *condParser) and() (condNode, error) {
+	x, err := p.not()
+	for err == nil {
+		if _, ok := p.isOperator("&&"); !ok {
+			break
+		}
+		p.i++
+		var y condNode
+		if y, err = p.not(); err == nil {
+			x = condBinary{"&&", x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *condParser) not() (condNode, error) {
+	if _, ok := p.isOperator("!"); ok {
+		p.i++
+		x, err := p.not()
+		return condNot{x}, err
+	}
+	return p.comparison()
+}
+
+func (p *condParser) comparison() (condNode, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := p.isOperator("==", "!=", "<", "<=", ">", ">="); ok {
+		p.i++
+		y, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return condBinary{op, x, y}, nil
+	}
+	return x, nil
+}
+
+func (p *condParser) operand() (condNode, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of condition, expecting a value")
+	}
+	p.i++
+	switch t.typ {
+	case condOperand:
+		return condLiteral(t.text), nil
+	case condReference:
+		return condRef(t.text), nil
+	case condLeftParen:
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.typ != condRightParen {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.i++
+		return x, nil
+	}
+	return nil, fmt.Errorf("unexpected %s, expecting a value", t.text)
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCondition(t *testing.T) {
+	state := map[string]string{
+		"$(In.Method)":     "POST",
+		"$(In.Body)":       "two words",
+		"$(Latest.Result)": "[]",
+		"$(Count.Stdout)":  " 10\n",
+		"$(Flag.Stdout)":   "0",
+		"${env:STAGE}":     "prod",
+	}
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"$(In.Method) == POST", true},
+		{"$(In.Method) != POST", false},
+		{`$(In.Body) == "two words"`, true},
+		{"$(In.Body) == 'two words'", true},
+		{"$(Latest.Result)", false},
+		{"!$(Latest.Result)", true},
+		{"$(Missing.Output)", false},
+		{"$(Missing.Output) == ''", true},
+		{"$(Flag.Stdout)", false},
+		{"${env:STAGE} == prod", true},
+		{"$(Count.Stdout) > 9", true},
+		{"$(Count.Stdout) >= 10", true},
+		{"$(Count.Stdout) < 9.5", false},
+		{"10 < 9", false},
+		{"abc < abd", true},
+		{"10 < abc", true},
+		{"$(In.Method) == POST && $(Latest.Result)", false},
+		{"$(In.Method) == GET || $(Count.Stdout) <= 10", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!!true", true},
+		{"! (true && $(Latest.Result))", true},
+		{"$(In.Method)==POST&&${env:STAGE}!=dev", true},
+	}
+	lookup := func(ref string) (string, bool) {
+		v, ok := state[ref]
+		return v, ok
+	}
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.text)
+		if err != nil {
+			t.Errorf("ParseCondition(%q): %v", tt.text, err)
+			continue
+		}
+		if got := c.Eval(lookup); got != tt.want {
+			t.Errorf("%s = %t, want %t", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestConditionShortCircuit(t *testing.T) {
+	var looked []string
+	lookup := func(ref string) (string, bool) {
+		looked = append(looked, ref)
+		return "", false
+	}
+	c, err := ParseCondition("$(A.Out) && $(B.Out) || $(C.Out)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Eval(lookup)
+	if strings.Join(looked, ",") != "$(A.Out),$(C.Out)" {
+		t.Errorf("looked up %q, want $(A.Out) and $(C.Out)", looked)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		text, err string
+	}{
+		{"", "unexpected end of condition, expecting a value"},
+		{"a ==", "unexpected end of condition, expecting a value"},
+		{"(a == b", "missing )"},
+		{"a == b)", "unexpected )"},
+		{"a b", "unexpected b"},
+		{"&& a", "unexpected &&, expecting a value"},
+		{`a == "open`, `unterminated string "open`},
+		{"a == 'open", "unterminated string 'open"},
+		{"$(In.Method == POST", "unterminated reference"},
+		{"${env:STAGE == prod", "unterminated reference"},
+		{"a = b", `unexpected '='`},
+	}
+	for _, tt := range tests {
+		_, err := ParseCondition(tt.text)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseCondition(%q) = %v, want %s", tt.text, err, tt.err)
+		}
+	}
+}
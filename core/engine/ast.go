@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// Pos is a 1-based line and column in a job file.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Job is the syntax tree of a single job file.
+type Job struct {
+	Name      string
+	Resources []*Resource
+	// Imports not yet replaced by the resources they name, see ResolveImports
+	Imports []*Import
+	// Sections are the on_failure and finally blocks of the job
+	Sections []*Section
+	// Comments following the last resource of the file
+	Comments []*Comment
+}
+
+// Import is an import "path" directive. Index is the number of resources
+// preceding it, which is where the imported resources are spliced in.
+type Import struct {
+	Pos   Pos
+	Path  string
+	Index int
+	// Comments directly preceding the import
+	Comments []*Comment
+}
+
+// Names of the sections a job may declare once each:
+//
+//	on_failure {
+//		<resources run when a task of the job failed>
+//	}
+//	finally {
+//		<resources run after every run of the job>
+//	}
+const (
+	OnFailureSection = "on_failure"
+	FinallySection   = "finally"
+)
+
+// Section is an on_failure or finally block of resources.
+type Section struct {
+	Pos       Pos
+	End       Pos
+	Name      string
+	Resources []*Resource
+	// Comments directly preceding the section
+	Comments []*Comment
+	// Comments between the last resource and the closing brace
+	Trailing []*Comment
+}
+
+// Section returns the section called name, or nil.
+func (job *Job) Section(name string) *Section {
+	for _, s := range job.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Comment is a single // comment line, Text includes the leading slashes.
+type Comment struct {
+	Pos  Pos
+	Text string
+}
+
+// Resource is a provider block:
+//
+//	<provider> <title> {
+//		<properties>
+//	}
+type Resource struct {
+	// File is the name of the job file the resource was parsed from, which
+	// differs from the job's own name for imported resources
+	File       string
+	Pos        Pos
+	End        Pos
+	Provider   string
+	Title      string
+	Properties []*Property
+	// Comments directly preceding the resource
+	Comments []*Comment
+	// Comments between the last property and the closing brace
+	Trailing []*Comment
+}
+
+// Property returns the property called name, or nil.
+func (r *Resource) Property(name string) *Property {
+	for _, p := range r.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Property is a name: value pair of a resource or an entry of a map value.
+type Property struct {
+	Pos   Pos
+	Name  string
+	Value Value
+	// Comments directly preceding the property
+	Comments []*Comment
+}
+
+// Value is one of *Scalar, *Array or *Map.
+type Value interface {
+	Position() Pos
+	// Interface returns the value as the plain JSON tree of scalars,
+	// []interface{} and map[string]interface{} that is sent to the runners.
+	Interface() interface{}
+}
+
+// ScalarStyle records how a scalar value was written in the job file.
+type ScalarStyle int
+
+const (
+	RawStyle ScalarStyle = iota
+	QuotedStyle
+	HeredocStyle
+)
+
+// Scalar is a raw, quoted or heredoc value. Text holds the decoded value,
+// Delim the heredoc delimiter. Raw values are typed: true, false, null and
+// numbers are sent to the runners as JSON literals, anything else, durations
+// like 15s included, as a string. Quoted and heredoc values are always strings.
+type Scalar struct {
+	Pos   Pos
+	Text  string
+	Style ScalarStyle
+	Delim string
+}
+
+func (s *Scalar) Position() Pos {
+	return s.Pos
+}
+
+func (s *Scalar) Interface() interface{} {
+	switch s.Type() {
+	case core.TypeBool:
+		return s.Text == "true"
+	case core.TypeInt, core.TypeNumber:
+		return json.Number(s.Text)
+	case core.TypeAny:
+		return nil
+	}
+	return s.Text
+}
+
+var numberLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// Type returns the type of the literal s holds, TypeAny stands for null.
+func (s *Scalar) Type() core.PropertyType {
+	if s.Style != RawStyle {
+		return core.TypeString
+	}
+	switch s.Text {
+	case "true", "false":
+		return core.TypeBool
+	case "null":
+		return core.TypeAny
+	}
+	if m := numberLiteral.FindStringSubmatch(s.Text); m != nil {
+		if len(m[2]) == 0 && len(m[3]) == 0 {
+			return core.TypeInt
+		}
+		return core.TypeNumber
+	}
+	if _, err := time.ParseDuration(s.Text); err == nil {
+		return core.TypeDuration
+	}
+	return core.TypeString
+}
+
+// Array is a [ ... ] value.
+type Array struct {
+	Pos   Pos
+	End   Pos
+	Elems []Value
+	// Comments[i] holds the comments directly preceding Elems[i]
+	Comments [][]*Comment
+	// Comments between the last element and the closing bracket
+	Trailing []*Comment
+}
+
+func (a *Array) Position() Pos {
+	return a.Pos
+}
+
+func (a *Array) Interface() interface{} {
+	arr := make([]interface{}, 0, len(a.Elems))
+	for _, e := range a.Elems {
+		arr = append(arr, e.Interface())
+	}
+	return arr
+}
+
+// Map is a { name: value ... } value.
+type Map struct {
+	Pos     Pos
+	End     Pos
+	Entries []*Property
+	// Comments between the last entry and the closing brace
+	Trailing []*Comment
+}
+
+func (m *Map) Position() Pos {
+	return m.Pos
+}
+
+func (m *Map) Interface() interface{} {
+	obj := make(map[string]interface{}, len(m.Entries))
+	for _, e := range m.Entries {
+		obj[e.Name] = e.Value.Interface()
+	}
+	return obj
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+func TestScalarType(t *testing.T) {
+	tests := []struct {
+		text  string
+		style ScalarStyle
+		typ   core.PropertyType
+		json  string
+	}{
+		{"true", RawStyle, core.TypeBool, `true`},
+		{"false", RawStyle, core.TypeBool, `false`},
+		{"null", RawStyle, core.TypeAny, `null`},
+		{"42", RawStyle, core.TypeInt, `42`},
+		{"-7", RawStyle, core.TypeInt, `-7`},
+		{"0.5", RawStyle, core.TypeNumber, `0.5`},
+		{"1e3", RawStyle, core.TypeNumber, `1e3`},
+		{"15s", RawStyle, core.TypeDuration, `"15s"`},
+		{"1h30m", RawStyle, core.TypeDuration, `"1h30m"`},
+		{"007", RawStyle, core.TypeString, `"007"`},
+		{"True", RawStyle, core.TypeString, `"True"`},
+		{"nodes", RawStyle, core.TypeString, `"nodes"`},
+		{"42", QuotedStyle, core.TypeString, `"42"`},
+		{"true", HeredocStyle, core.TypeString, `"true"`},
+	}
+	for _, tt := range tests {
+		s := &Scalar{Text: tt.text, Style: tt.style}
+		b, err := json.Marshal(s.Interface())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Type() != tt.typ || string(b) != tt.json {
+			t.Errorf("%q (style %d) is %s as %s, want %s as %s", tt.text, tt.style, s.Type(), b, tt.typ, tt.json)
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	scalar := func(text string) Value { return &Scalar{Text: text} }
+	tests := []struct {
+		value Value
+		want  core.PropertyType
+		ok    bool
+	}{
+		{scalar("nodes"), core.TypeString, true},
+		{scalar("42"), core.TypeString, true},
+		{scalar("42"), core.TypeNumber, true},
+		{scalar("42"), core.TypeDuration, true},
+		{scalar("0.5"), core.TypeDuration, true},
+		{scalar("0.5"), core.TypeInt, false},
+		{scalar("15s"), core.TypeInt, false},
+		{scalar("nodes"), core.TypeBool, false},
+		{scalar("null"), core.TypeInt, true},
+		{scalar("null"), core.TypeArray, true},
+		{scalar("$(Query.Count)"), core.TypeInt, true},
+		{scalar("${env:LIMIT}"), core.TypeInt, true},
+		{scalar("$(Query.Result)"), core.TypeArray, false},
+		{&Array{}, core.TypeArray, true},
+		{&Array{}, core.TypeString, false},
+		{&Map{}, core.TypeAny, true},
+	}
+	for _, tt := range tests {
+		var got core.PropertyType
+		switch value := tt.value.(type) {
+		case *Scalar:
+			got = value.Type()
+		case *Array:
+			got = core.TypeArray
+		case *Map:
+			got = core.TypeMap
+		}
+		if ok := assignable(tt.value, got, tt.want); ok != tt.ok {
+			t.Errorf("assignable(%s %v, %s) = %t, want %t", got, tt.value.Interface(), tt.want, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is read from the root of the jobs directory. It holds one
+// pattern per line, blank lines and lines starting with # are skipped.
+// Patterns are matched against the slash separated path relative to the
+// root, patterns without a slash against the file or directory name alone,
+// and patterns ending in a slash only match directories:
+//
+//	# work in progress
+//	drafts/
+//	*.wip.job
+const IgnoreFile = ".jobignore"
+
+// ErrorList collects the errors of several job files so that one broken
+// file does not stop the others from loading.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	var msgs []string
+	for _, e := range l {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns l as an error, or nil when it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// JobFiles returns the paths of the job files in the directory root and its
+// subdirectories. Files imported by another job file are fragments shared by
+// the jobs importing them, they are left out as they are no jobs of their
+// own. Files that only import one another in a cycle are kept, so that the
+// cycle is reported when they are loaded.
+func JobFiles(root string) (files []string, err error) {
+	if root, err = filepath.Abs(root); err != nil {
+		return
+	}
+	var sources []string
+	if sources, err = SourceFiles(root); err != nil {
+		return
+	}
+	fragments := importedFiles(sources, root)
+	for _, f := range sources {
+		if !fragments[f] {
+			files = append(files, f)
+		}
+	}
+	return
+}
+
+// importedFiles returns the files imported, directly or through other
+// fragments, by the files of sources that no file imports. Files that do not
+// parse import nothing, their errors are reported when they are loaded.
+func importedFiles(sources []string, root string) map[string]bool {
+	imports := make(map[string][]string)
+	importers := make(map[string]int)
+	for _, f := range sources {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		job, err := Parse(JobName(f, root), src)
+		if err != nil {
+			continue
+		}
+		for _, imp := range job.Imports {
+			p := filepath.Join(root, filepath.FromSlash(path.Clean(imp.Path)))
+			imports[f] = append(imports[f], p)
+			importers[p]++
+		}
+	}
+	fragments := make(map[string]bool)
+	var visit func(f string)
+	visit = func(f string) {
+		for _, p := range imports[f] {
+			if !fragments[p] {
+				fragments[p] = true
+				visit(p)
+			}
+		}
+	}
+	for _, f := range sources {
+		if importers[f] == 0 {
+			visit(f)
+		}
+	}
+	return fragments
+}
+
+// SourceFiles returns the paths of the .job files in the directory root and
+// its subdirectories, fragments included, leaving out hidden files and those
+// matched by IgnoreFile.
+func SourceFiles(root string) (files []string, err error) {
+	if !filepath.IsAbs(root) {
+		if root, err = filepath.Abs(root); err != nil {
+			return
+		}
+	}
+	var ignore []string
+	if ignore, err = readIgnoreFile(filepath.Join(root, IgnoreFile)); err != nil {
+		return
+	}
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(info.Name(), ".") || ignored(ignore, rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+		if filepath.Ext(p) != ".job" {
+			if strings.Contains(strings.ToLower(info.Name()), "job") {
+				log.Printf("skipping %s: job files must end in .job\n", rel)
+			}
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return
+}
+
+// JobName returns the name of the job file at p, its slash separated path
+// relative to root.
+func JobName(p, root string) string {
+	ap, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	ar, err := filepath.Abs(root)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	rel, err := filepath.Rel(ar, ap)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func readIgnoreFile(p string) (patterns []string, err error) {
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, s.Err()
+}
+
+// ignored reports whether the path rel is matched by one of patterns.
+func ignored(patterns []string, rel string, dir bool) bool {
+	for _, pat := range patterns {
+		if strings.HasSuffix(pat, "/") {
+			if !dir {
+				continue
+			}
+			pat = strings.TrimSuffix(pat, "/")
+		}
+		pat = strings.TrimPrefix(pat, "/")
+		name := rel
+		if !strings.Contains(pat, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
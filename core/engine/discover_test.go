@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeTree creates files, keyed by their slash separated path, in a fresh
+// directory and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root, err := ioutil.TempDir("", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// names returns the job names of files relative to root, sorted.
+func names(files []string, root string) (out []string) {
+	for _, f := range files {
+		out = append(out, JobName(f, root))
+	}
+	sort.Strings(out)
+	return
+}
+
+const razorFragment = `mongo RazorNodes {
+	Database: razor
+	Collection: nodes
+}
+`
+
+func TestJobFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"rpc.job": `import "common/razor.job"
+
+listener ListenForConnections {
+	Method: Respond
+	Response: $(RazorNodes.Result)
+}
+`,
+		"common/razor.job":     razorFragment,
+		"common/nested.job":    "import \"common/razor.job\"\n",
+		"reports/daily.job":    "import \"common/nested.job\"\n",
+		"cycle/a.job":          "import \"cycle/b.job\"\n",
+		"cycle/b.job":          "import \"cycle/a.job\"\n",
+		"broken.job":           "mongo {\n",
+		"drafts/wip.job":       razorFragment,
+		"skip.wip.job":         razorFragment,
+		".hidden.job":          razorFragment,
+		".git/config.job":      razorFragment,
+		"notes.txt":            "not a job",
+		"job.json":             "{}",
+		IgnoreFile:             "# work in progress\ndrafts/\n*.wip.job\n",
+		"templates/latest.job": "param Collection {\n}\n",
+	})
+	defer os.RemoveAll(root)
+
+	sources, err := SourceFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"broken.job", "common/nested.job", "common/razor.job", "cycle/a.job", "cycle/b.job", "reports/daily.job", "rpc.job", "templates/latest.job"}
+	if got := names(sources, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceFiles = %q, want %q", got, want)
+	}
+
+	files, err := JobFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"broken.job", "cycle/a.job", "cycle/b.job", "reports/daily.job", "rpc.job", "templates/latest.job"}
+	if got := names(files, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("JobFiles = %q, want %q", got, want)
+	}
+}
+
+func TestParseDirectory(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"rpc.job":          "import \"common/razor.job\"\n",
+		"common/razor.job": razorFragment,
+		"broken.job":       "mongo {\n",
+		"cycle/a.job":      "import \"cycle/b.job\"\n",
+		"cycle/b.job":      "import \"cycle/a.job\"\n",
+	})
+	defer os.RemoveAll(root)
+
+	jobs, err := ParseDirectory(root)
+	var loaded []string
+	for _, job := range jobs {
+		loaded = append(loaded, job.Name)
+	}
+	if !reflect.DeepEqual(loaded, []string{"rpc.job"}) {
+		t.Errorf("loaded %q, want only rpc.job", loaded)
+	}
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("error %v, want one for each of broken.job, cycle/a.job and cycle/b.job", err)
+	}
+}
+
+func TestJobName(t *testing.T) {
+	root := filepath.FromSlash("/srv/jobs")
+	tests := []struct {
+		path, want string
+	}{
+		{"/srv/jobs/rpc.job", "rpc.job"},
+		{"/srv/jobs/reports/daily.job", "reports/daily.job"},
+		{"/elsewhere/other.job", "other.job"},
+	}
+	for _, tt := range tests {
+		if got := JobName(filepath.FromSlash(tt.path), root); got != tt.want {
+			t.Errorf("JobName(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Format returns the canonical source of job: one tab of indentation per
+// level, a single space after a property's colon, one blank line between
+// resources and comments kept in place. Blank lines between top level
+// comments are preserved, runs of them are collapsed into one. The on_failure
+// and finally sections follow the resources of the job.
+func Format(job *Job) []byte {
+	p := &printer{}
+	var prevLine int
+	imports := job.Imports
+	for i, r := range job.Resources {
+		prevLine = p.imports(&imports, i, prevLine)
+		if p.buf.Len() > 0 {
+			p.blank()
+		}
+		prevLine = p.comments(r.Comments, prevLine, r.Pos.Line)
+		p.resource(r)
+		prevLine = r.End.Line
+	}
+	prevLine = p.imports(&imports, len(job.Resources), prevLine)
+	for _, s := range job.Sections {
+		if p.buf.Len() > 0 {
+			p.blank()
+		}
+		prevLine = p.comments(s.Comments, prevLine, s.Pos.Line)
+		p.section(s)
+		prevLine = s.End.Line
+	}
+	if len(job.Comments) > 0 && p.buf.Len() > 0 {
+		p.blank()
+	}
+	p.comments(job.Comments, prevLine, 0)
+	return p.buf.Bytes()
+}
+
+// FormatSource parses src and returns it in canonical form.
+func FormatSource(name string, src []byte) ([]byte, error) {
+	job, err := Parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return Format(job), nil
+}
+
+type printer struct {
+	buf   bytes.Buffer
+	depth int
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	p.buf.WriteString(strings.Repeat("\t", p.depth))
+	fmt.Fprintf(&p.buf, format, args...)
+	p.buf.WriteByte('\n')
+}
+
+func (p *printer) blank() {
+	if p.buf.Len() > 0 && !bytes.HasSuffix(p.buf.Bytes(), []byte("\n\n")) {
+		p.buf.WriteByte('\n')
+	}
+}
+
+// comments prints top level comments, keeping a blank line wherever the
+// source had one after prevLine. next is the line of whatever follows the
+// comments, or zero at the end of the file.
+func (p *printer) comments(comments []*Comment, prevLine, next int) int {
+	for _, c := range comments {
+		if prevLine > 0 && c.Pos.Line > prevLine+1 {
+			p.blank()
+		}
+		p.line("%s", c.Text)
+		prevLine = c.Pos.Line
+	}
+	if len(comments) > 0 && next > prevLine+1 {
+		p.blank()
+	}
+	return prevLine
+}
+
+// imports prints the imports preceding the resource at index, consecutive
+// imports are kept together as one group.
+func (p *printer) imports(imports *[]*Import, index, prevLine int) int {
+	for len(*imports) > 0 && (*imports)[0].Index == index {
+		imp := (*imports)[0]
+		*imports = (*imports)[1:]
+		if p.buf.Len() > 0 && (len(imp.Comments) > 0 || imp.Pos.Line > prevLine+1) {
+			p.blank()
+		}
+		prevLine = p.comments(imp.Comments, prevLine, imp.Pos.Line)
+		p.line("import %s", quote(imp.Path))
+		prevLine = imp.Pos.Line
+	}
+	return prevLine
+}
+
+func (p *printer) section(s *Section) {
+	p.line("%s {", s.Name)
+	p.depth++
+	for i, r := range s.Resources {
+		if i > 0 {
+			p.blank()
+		}
+		for _, c := range r.Comments {
+			p.line("%s", c.Text)
+		}
+		p.resource(r)
+	}
+	for _, c := range s.Trailing {
+		p.line("%s", c.Text)
+	}
+	p.depth--
+	p.line("}")
+}
+
+func (p *printer) resource(r *Resource) {
+	if len(r.Title) == 0 {
+		p.line("%s {", r.Provider)
+	} else {
+		p.line("%s %s {", r.Provider, r.Title)
+	}
+	p.depth++
+	for _, prop := range r.Properties {
+		for _, c := range prop.Comments {
+			p.line("%s", c.Text)
+		}
+		p.property(prop)
+	}
+	for _, c := range r.Trailing {
+		p.line("%s", c.Text)
+	}
+	p.depth--
+	p.line("}")
+}
+
+func (p *printer) property(prop *Property) {
+	p.value(prop.Name+":", prop.Value)
+}
+
+// value prints v on a line starting with prefix, which is the property name
+// and colon or empty for array elements.
+func (p *printer) value(prefix string, v Value) {
+	switch v := v.(type) {
+	case *Scalar:
+		p.scalar(prefix, v)
+	case *Array:
+		if len(v.Elems) == 0 && len(v.Trailing) == 0 {
+			p.line("%s[]", prefix)
+			return
+		}
+		p.line("%s[", prefix)
+		p.depth++
+		for i, e := range v.Elems {
+			if i < len(v.Comments) {
+				for _, c := range v.Comments[i] {
+					p.line("%s", c.Text)
+				}
+			}
+			p.value("", e)
+		}
+		for _, c := range v.Trailing {
+			p.line("%s", c.Text)
+		}
+		p.depth--
+		p.line("]")
+	case *Map:
+		if len(v.Entries) == 0 && len(v.Trailing) == 0 {
+			p.line("%s{}", prefix)
+			return
+		}
+		p.line("%s{", prefix)
+		p.depth++
+		for _, e := range v.Entries {
+			for _, c := range e.Comments {
+				p.line("%s", c.Text)
+			}
+			p.property(e)
+		}
+		for _, c := range v.Trailing {
+			p.line("%s", c.Text)
+		}
+		p.depth--
+		p.line("}")
+	}
+}
+
+func (p *printer) scalar(prefix string, s *Scalar) {
+	if len(prefix) > 0 {
+		prefix += " "
+	}
+	switch {
+	case s.Style == HeredocStyle:
+		p.heredoc(prefix, s)
+	case s.Style == QuotedStyle || !rawSafe(s.Text, len(prefix) == 0):
+		p.line("%s%s", prefix, quote(s.Text))
+	default:
+		p.line("%s", strings.TrimRight(prefix+s.Text, " "))
+	}
+}
+
+// heredoc prints s as an indented <<- heredoc. Bodies that <<- would change,
+// because every line is indented or a line holds only whitespace, are kept
+// verbatim in a plain << heredoc instead.
+func (p *printer) heredoc(prefix string, s *Scalar) {
+	delim := s.Delim
+	if len(delim) == 0 {
+		delim = "EOF"
+	}
+	lines := strings.Split(s.Text, "\n")
+	if verbatim(lines) {
+		p.line("%s<<%s", prefix, delim)
+		for _, l := range lines {
+			p.buf.WriteString(l)
+			p.buf.WriteByte('\n')
+		}
+		p.buf.WriteString(delim)
+		p.buf.WriteByte('\n')
+		return
+	}
+	p.line("%s<<-%s", prefix, delim)
+	p.depth++
+	for _, l := range lines {
+		if len(l) == 0 {
+			p.buf.WriteByte('\n')
+			continue
+		}
+		p.line("%s", l)
+	}
+	p.depth--
+	p.line("%s", delim)
+}
+
+func verbatim(lines []string) bool {
+	indented, text := true, false
+	for _, l := range lines {
+		if len(l) == 0 {
+			continue
+		}
+		if strings.TrimSpace(l) == "" {
+			return true
+		}
+		text = true
+		if l[0] != ' ' && l[0] != '\t' {
+			indented = false
+		}
+	}
+	return text && indented
+}
+
+// rawSafe reports whether text reads back unchanged when written unquoted.
+func rawSafe(text string, element bool) bool {
+	if len(text) == 0 {
+		return !element
+	}
+	if strings.TrimSpace(text) != text || strings.HasPrefix(text, "<<") {
+		return false
+	}
+	switch text[0] {
+	case '"', '[', '{':
+		return false
+	case ']':
+		if element {
+			return false
+		}
+	}
+	for _, r := range text {
+		if r < 32 {
+			return false
+		}
+	}
+	return true
+}
+
+// quote returns text as a double-quoted string using the escapes understood
+// by the tokenizer.
+func quote(text string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range text {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 32 {
+				fmt.Fprintf(&buf, `\u%04x`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,218 @@
+package engine
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "indentation and spacing",
+			src: `// nodes
+mongo   Nodes   {
+  Database:razor
+      Collection:    nodes
+}
+localexec Report {
+Args:[
+-c
+"two words"
+]
+      File: report
+}`,
+			want: `// nodes
+mongo Nodes {
+	Database: razor
+	Collection: nodes
+}
+
+localexec Report {
+	Args:[
+		-c
+		"two words"
+	]
+	File: report
+}
+`,
+		},
+		{
+			name: "comments and blank lines",
+			src: `// first
+
+
+// second
+import "shared/db.job"
+import "shared/log.job"
+x A {
+	// about B
+	B: 1
+	// trailing
+}
+
+
+
+// the end
+`,
+			want: `// first
+
+// second
+import "shared/db.job"
+import "shared/log.job"
+
+x A {
+	// about B
+	B: 1
+	// trailing
+}
+
+// the end
+`,
+		},
+		{
+			name: "values that must stay quoted",
+			src: `x A {
+	Empty: ""
+	Padded: "  x  "
+	Brace: "{"
+	Heredoc: "<<EOF"
+	Newline: "a\nb"
+}
+`,
+			want: `x A {
+	Empty: ""
+	Padded: "  x  "
+	Brace: "{"
+	Heredoc: "<<EOF"
+	Newline: "a\nb"
+}
+`,
+		},
+		{
+			name: "heredocs",
+			src: `x A {
+	Script: <<EOF
+echo a
+  echo b
+EOF
+	Verbatim: <<SH
+  all indented
+SH
+}
+`,
+			want: `x A {
+	Script: <<-EOF
+		echo a
+		  echo b
+	EOF
+	Verbatim: <<SH
+  all indented
+SH
+}
+`,
+		},
+		{
+			name: "nested values and sections",
+			src: `job {
+parallel: true
+}
+mongo Active {
+Pipeline:[
+{
+$match:{
+status: active
+}
+}
+{}
+]
+}
+finally {
+localexec Cleanup {
+File: cleanup
+Args:[]
+}
+}
+`,
+			want: `job {
+	parallel: true
+}
+
+mongo Active {
+	Pipeline:[
+		{
+			$match:{
+				status: active
+			}
+		}
+		{}
+	]
+}
+
+finally {
+	localexec Cleanup {
+		File: cleanup
+		Args:[]
+	}
+}
+`,
+		},
+		{
+			name: "comments inside maps and arrays",
+			src: `mongo Active {
+Query:{ // note
+x: 1
+// trailing
+}
+Args:[
+// first
+-c
+/usr/bin
+]
+Env:{
+// nothing yet
+}
+}
+`,
+			want: `mongo Active {
+	Query:{
+		// note
+		x: 1
+		// trailing
+	}
+	Args:[
+		// first
+		-c
+		/usr/bin
+	]
+	Env:{
+		// nothing yet
+	}
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := FormatSource("test.job", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("Format:\n%s\nwant:\n%s", out, tt.want)
+			}
+			// fmt -check passes on formatted files
+			again, err := FormatSource("test.job", out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(out) {
+				t.Errorf("Format is not idempotent:\n%s\nthen:\n%s", out, again)
+			}
+			// formatting keeps the meaning of every property
+			if before, after := properties(t, tt.src), properties(t, string(out)); before != after {
+				t.Errorf("properties changed from %s to %s", before, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// DependsOnOption names the tasks a task of a parallel job waits for:
+//
+//	job {
+//		parallel: true
+//	}
+//	localexec Merge {
+//		...
+//		depends_on:[
+//			RazorNodes
+//			RazorPolicies
+//		]
+//	}
+const DependsOnOption = "depends_on"
+
+// Tasks returns the resources of job that run as its main tasks, all but the
+// job block.
+func (job *Job) Tasks() (tasks []*Resource) {
+	for _, r := range job.Resources {
+		if r.Provider != JobProvider {
+			tasks = append(tasks, r)
+		}
+	}
+	return
+}
+
+// jobDependencies returns the Dependencies of the tasks of job when it runs
+// in parallel and nil otherwise. depends_on is only allowed in parallel jobs
+// and never in a section, whose tasks always run in order.
+func jobDependencies(job *Job, parallel bool) ([][]int, error) {
+	for _, s := range job.Sections {
+		for _, r := range s.Resources {
+			if p := r.Property(DependsOnOption); p != nil {
+				return nil, &ParseError{File: r.File, Pos: p.Pos, Msg: fmt.Sprintf("%s is not supported in %s, its tasks run in order", DependsOnOption, s.Name)}
+			}
+		}
+	}
+	tasks := job.Tasks()
+	if parallel {
+		return Dependencies(tasks)
+	}
+	for _, r := range tasks {
+		if p := r.Property(DependsOnOption); p != nil {
+			return nil, &ParseError{File: r.File, Pos: p.Pos, Msg: fmt.Sprintf("%s requires parallel: true in the job block", DependsOnOption)}
+		}
+	}
+	return nil, nil
+}
+
+// Dependencies returns the indices of the tasks each of tasks waits for when
+// the job runs in parallel: those named by depends_on and those its
+// $(Task.Output) references point to. A title names the nearest task before
+// the dependent with that title, or the first one after it when there is
+// none, so the Respond of a listener depends on its own Listen only. Unknown
+// titles and dependency cycles are reported as a *ParseError.
+func Dependencies(tasks []*Resource) (deps [][]int, err error) {
+	titles := make(map[string][]int)
+	for i, r := range tasks {
+		titles[r.Title] = append(titles[r.Title], i)
+	}
+	resolve := func(i int, title string) (task int, ok bool) {
+		task = -1
+		for _, k := range titles[title] {
+			if k < i {
+				task = k
+			} else if k > i && task < 0 {
+				return k, true
+			}
+		}
+		return task, task >= 0
+	}
+
+	deps = make([][]int, len(tasks))
+	for i, r := range tasks {
+		indices := make(map[int]bool)
+		if p := r.Property(DependsOnOption); p != nil {
+			a, ok := p.Value.(*Array)
+			if !ok {
+				return nil, &ParseError{File: r.File, Pos: p.Pos, Msg: fmt.Sprintf("%s of %s must be an array of task titles", DependsOnOption, describeResource(r))}
+			}
+			for _, e := range a.Elems {
+				s, ok := e.(*Scalar)
+				if !ok {
+					return nil, &ParseError{File: r.File, Pos: e.Position(), Msg: fmt.Sprintf("%s of %s must be an array of task titles", DependsOnOption, describeResource(r))}
+				}
+				k, ok := resolve(i, s.Text)
+				if !ok {
+					msg := fmt.Sprintf("%s depends on unknown task %s", describeResource(r), s.Text)
+					if s.Text == r.Title {
+						msg = fmt.Sprintf("%s cannot depend on itself", describeResource(r))
+					}
+					return nil, &ParseError{File: r.File, Pos: s.Pos, Msg: msg}
+				}
+				indices[k] = true
+			}
+		}
+		for _, p := range r.Properties {
+			for _, ref := range references(p.Value) {
+				if dot := strings.Index(ref, "."); dot > 0 {
+					ref = ref[:dot]
+				}
+				// unproduced references are reported by Validate
+				if k, ok := resolve(i, ref); ok {
+					indices[k] = true
+				}
+			}
+		}
+		for k := range indices {
+			deps[i] = append(deps[i], k)
+		}
+		sort.Ints(deps[i])
+	}
+
+	// depth first search, a task met again while it is on the path closes
+	// a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(tasks))
+	var path []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		state[i] = visiting
+		path = append(path, i)
+		for _, k := range deps[i] {
+			switch state[k] {
+			case visiting:
+				return cycleError(tasks, path, k)
+			case unvisited:
+				if err := visit(k); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range tasks {
+		if state[i] == unvisited {
+			if err = visit(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// cycleError reports the cycle of path starting at task k.
+func cycleError(tasks []*Resource, path []int, k int) error {
+	var names []string
+	for n := len(path) - 1; n >= 0; n-- {
+		names = append(names, tasks[path[n]].Title)
+		if path[n] == k {
+			break
+		}
+	}
+	// path is walked backwards, from the dependent to its dependencies
+	for a, b := 0, len(names)-1; a < b; a, b = a+1, b-1 {
+		names[a], names[b] = names[b], names[a]
+	}
+	r := tasks[k]
+	return &ParseError{File: r.File, Pos: r.Pos, Msg: fmt.Sprintf("dependency cycle: %s -> %s", strings.Join(names, " -> "), r.Title)}
+}
+
+// references returns the $(...) references in every scalar of value.
+func references(value Value) (refs []string) {
+	switch v := value.(type) {
+	case *Array:
+		for _, e := range v.Elems {
+			refs = append(refs, references(e)...)
+		}
+	case *Map:
+		for _, e := range v.Entries {
+			refs = append(refs, references(e.Value)...)
+		}
+	case *Scalar:
+		for _, content := range core.FindExpressions(v.Text) {
+			// invalid expressions are reported by Validate
+			if e, err := core.ParseExpression(content); err == nil {
+				refs = append(refs, e.Ref)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		deps [][]int
+		err  string
+	}{
+		{
+			name: "references and depends_on",
+			src: `
+mongo Nodes {
+	Database: razor
+	Collection: nodes
+}
+mongo Policies {
+	Database: razor
+	Collection: policies
+}
+localexec Merge {
+	File: /usr/local/bin/merge
+	Args:[
+		$(Nodes.Result)
+		$(Policies.Result)
+	]
+}
+localexec Notify {
+	File: /usr/local/bin/notify
+	Args:[
+		merged
+	]
+	depends_on:[
+		Merge
+	]
+}`,
+			deps: [][]int{nil, nil, {0, 1}, {2}},
+		},
+		{
+			name: "listener tasks share a title",
+			src: `
+listener In {
+	Method: Listen
+	Path: /merge
+}
+localexec Merge {
+	File: /usr/local/bin/merge
+	Args:[
+		$(In.Body)
+	]
+}
+listener In {
+	Method: Respond
+	Response: $(Merge.Stdout)
+}
+localexec Log {
+	File: /usr/local/bin/log
+	Args:[
+		$(In.Method)
+	]
+}`,
+			deps: [][]int{nil, {0}, {1}, {2}},
+		},
+		{
+			name: "depends_on a later task",
+			src: `
+localexec First {
+	File: /bin/true
+	Args:[]
+	depends_on:[
+		Second
+	]
+}
+localexec Second {
+	File: /bin/true
+	Args:[]
+}`,
+			deps: [][]int{{1}, nil},
+		},
+		{
+			name: "cycle",
+			src: `
+localexec A {
+	File: /bin/echo
+	Args:[
+		$(B.Stdout)
+	]
+}
+localexec B {
+	File: /bin/echo
+	Args:[
+		$(A.Stdout)
+	]
+}`,
+			err: "dependency cycle: A -> B -> A",
+		},
+		{
+			name: "unknown task",
+			src: `
+localexec A {
+	File: /bin/true
+	Args:[]
+	depends_on:[
+		Missing
+	]
+}`,
+			err: "depends on unknown task Missing",
+		},
+		{
+			name: "itself",
+			src: `
+localexec A {
+	File: /bin/true
+	Args:[]
+	depends_on:[
+		A
+	]
+}`,
+			err: "cannot depend on itself",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := Parse("test.job", []byte("job {\n\tparallel: true\n}"+tt.src))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			deps, err := jobDependencies(job, true)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(deps, tt.deps) {
+				t.Errorf("dependencies = %v, want %v", deps, tt.deps)
+			}
+		})
+	}
+}
+
+func TestCompileAfter(t *testing.T) {
+	job, err := Parse("test.job", []byte(`
+job {
+	parallel: true
+}
+listener In {
+	Method: Listen
+	Path: /merge
+}
+localexec Merge {
+	File: /usr/local/bin/merge
+	Args:[
+		$(In.Body)
+	]
+}
+listener In {
+	Method: Respond
+	Response: $(Merge.Stdout)
+}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	rpc, err := Compile(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after [][]int
+	for _, obj := range rpc.Objects {
+		after = append(after, obj.Options.After)
+	}
+	if want := [][]int{nil, {0}, {1}}; !reflect.DeepEqual(after, want) {
+		t.Errorf("After = %v, want %v", after, want)
+	}
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ResolveImports replaces every import directive of job with the resources of
+// the file it names. Paths are relative to the jobs directory root and
+// imported files may import further files, an import cycle is an error.
+func ResolveImports(job *Job, root string) error {
+	return resolveImports(job, root, []string{filepath.ToSlash(job.Name)})
+}
+
+func resolveImports(job *Job, root string, chain []string) error {
+	if len(job.Imports) == 0 {
+		return nil
+	}
+	var resources []*Resource
+	next := 0
+	for _, imp := range job.Imports {
+		resources = append(resources, job.Resources[next:imp.Index]...)
+		next = imp.Index
+
+		imported, err := importFile(job, imp, root, chain)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, imported...)
+	}
+	job.Resources = append(resources, job.Resources[next:]...)
+	job.Imports = nil
+	return nil
+}
+
+func importFile(job *Job, imp *Import, root string, chain []string) ([]*Resource, error) {
+	errorf := func(format string, args ...interface{}) error {
+		return &ParseError{
+			File: job.Name,
+			Pos:  imp.Pos,
+			Msg:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	name, ok := jobsPath(imp.Path)
+	if !ok {
+		return nil, errorf("import %q must be relative to the jobs directory", imp.Path)
+	}
+	for i, c := range chain {
+		if c == name {
+			cycle := append(append([]string{}, chain[i:]...), name)
+			return nil, errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, errorf("cannot import %q: %v", imp.Path, err)
+	}
+	imported, err := Parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	if err = resolveImports(imported, root, append(chain, name)); err != nil {
+		return nil, err
+	}
+	if len(imported.Sections) > 0 {
+		s := imported.Sections[0]
+		return nil, &ParseError{File: name, Pos: s.Pos, Msg: fmt.Sprintf("%s cannot be declared in an imported file", s.Name)}
+	}
+	return imported.Resources, nil
+}
+
+// jobsPath cleans path, a slash separated path relative to the jobs
+// directory. ok is false for absolute paths and paths leaving the directory.
+func jobsPath(path string) (name string, ok bool) {
+	name = filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+	ok = !filepath.IsAbs(path) && !strings.HasPrefix(path, "/") && name != ".." && !strings.HasPrefix(name, "../")
+	return
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveImports(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"common/razor.job":   razorFragment,
+		"common/nested.job":  "import \"common/razor.job\"\n\nlocalexec Log {\n\tFile: echo\n}\n",
+		"common/finally.job": "finally {\n}\n",
+		"common/broken.job":  "mongo {\n",
+		"cycle/a.job":        "import \"cycle/b.job\"\n",
+		"cycle/b.job":        "import \"cycle/a.job\"\n",
+		"cycle/self.job":     "import \"cycle/self.job\"\n",
+	})
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		name   string
+		file   string
+		src    string
+		titles []string
+		err    string
+	}{
+		{
+			name:   "import in place",
+			file:   "rpc.job",
+			src:    "localexec First {\n}\nimport \"common/razor.job\"\nlocalexec Last {\n}\n",
+			titles: []string{"First", "RazorNodes", "Last"},
+		},
+		{
+			name:   "nested import",
+			file:   "rpc.job",
+			src:    "import \"common/nested.job\"\n",
+			titles: []string{"RazorNodes", "Log"},
+		},
+		{
+			name:   "clean path",
+			file:   "rpc.job",
+			src:    "import \"common/../common/./razor.job\"\n",
+			titles: []string{"RazorNodes"},
+		},
+		{
+			name: "missing file",
+			file: "rpc.job",
+			src:  "import \"common/missing.job\"\n",
+			err:  `rpc.job:1:1: cannot import "common/missing.job"`,
+		},
+		{
+			name: "outside the root",
+			file: "rpc.job",
+			src:  "import \"../secrets.job\"\n",
+			err:  `rpc.job:1:1: import "../secrets.job" must be relative to the jobs directory`,
+		},
+		{
+			name: "cycle",
+			file: "cycle/a.job",
+			src:  "import \"cycle/b.job\"\n",
+			err:  "cycle/b.job:1:1: import cycle: cycle/a.job -> cycle/b.job -> cycle/a.job",
+		},
+		{
+			name: "import of itself",
+			file: "cycle/self.job",
+			src:  "import \"cycle/self.job\"\n",
+			err:  "cycle/self.job:1:1: import cycle: cycle/self.job -> cycle/self.job",
+		},
+		{
+			name: "sections in imported file",
+			file: "rpc.job",
+			src:  "import \"common/finally.job\"\n",
+			err:  "common/finally.job:1:1: finally cannot be declared in an imported file",
+		},
+		{
+			name: "parse error in imported file",
+			file: "rpc.job",
+			src:  "import \"common/broken.job\"\n",
+			err:  "common/broken.job:1:7:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := Parse(tt.file, []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ResolveImports(job, root)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for _, r := range job.Resources {
+				titles = append(titles, r.Title)
+			}
+			if strings.Join(titles, ",") != strings.Join(tt.titles, ",") || len(job.Imports) > 0 {
+				t.Errorf("resources = %q (%d imports left), want %q", titles, len(job.Imports), tt.titles)
+			}
+		})
+	}
+}
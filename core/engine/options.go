@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// JobProvider is the name of the title-less block holding the options of a
+// whole job:
+//
+//	job {
+//		timeout: 5m
+//	}
+const JobProvider = "job"
+
+// TaskOptionsSchema describes the lower case properties every resource
+// accepts next to those of its provider, see core.TaskOptions.
+var TaskOptionsSchema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "timeout", Type: core.TypeDuration, Description: "cancel the task when it runs for longer"},
+		{Name: "retry", Type: core.TypeMap, Description: "run the task again when it fails, see core.RetryPolicy"},
+		{Name: "when", Type: core.TypeString, Description: "skip the task unless the condition holds, see core.Condition"},
+		{Name: "foreach", Type: core.TypeAny, Description: "run the task for every item of an array or a $(Task.Output) reference to one"},
+		{Name: "concurrency", Type: core.TypeInt, Description: "number of foreach items run at a time, 1 by default"},
+		{Name: DependsOnOption, Type: core.TypeArray, Description: "titles of the tasks to wait for in a parallel job"},
+	},
+	// outputs published by the runner for every task
+	Outputs: []string{"Attempts"},
+}
+
+// JobOptionsSchema describes the properties of the job block, see
+// core.JobOptions.
+var JobOptionsSchema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "timeout", Type: core.TypeDuration, Description: "cancel a run of the job when it takes longer"},
+		{Name: "parallel", Type: core.TypeBool, Description: "run independent tasks concurrently, see depends_on"},
+	},
+}
+
+// JobBlock returns the job block of job, or nil.
+func (job *Job) JobBlock() *Resource {
+	for _, r := range job.Resources {
+		if r.Provider == JobProvider {
+			return r
+		}
+	}
+	return nil
+}
+
+// splitOptions separates the properties of r described by options from the
+// others and decodes them into v, which is checked if it has a Check method.
+func splitOptions(r *Resource, options core.Schema, v interface{}) (properties map[string]interface{}, err error) {
+	properties = make(map[string]interface{}, len(r.Properties))
+	opts := make(map[string]interface{})
+	var pos Pos
+	for _, p := range r.Properties {
+		if _, ok := options.Property(p.Name); ok {
+			if len(opts) == 0 {
+				pos = p.Pos
+			}
+			opts[p.Name] = p.Value.Interface()
+			continue
+		}
+		properties[p.Name] = p.Value.Interface()
+	}
+	if len(opts) == 0 {
+		return
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(v); err == nil {
+		if c, ok := v.(interface {
+			Check() error
+		}); ok {
+			err = c.Check()
+		}
+	}
+	if err != nil {
+		err = &ParseError{File: r.File, Pos: pos, Msg: fmt.Sprintf("options of %s: %v", describeResource(r), err)}
+	}
+	return
+}
+
+func describeResource(r *Resource) string {
+	if len(r.Title) == 0 {
+		return r.Provider
+	}
+	return fmt.Sprintf("%s %s", r.Provider, r.Title)
+}
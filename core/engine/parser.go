@@ -5,167 +5,457 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/Kozical/taskengine/core"
 )
 
+// ParseError describes a syntax error at a position in a job file.
+type ParseError struct {
+	File string
+	Pos  Pos
+	Msg  string
+	// Line holds the source line the error occurred on
+	Line string
+}
+
+func (e *ParseError) Error() string {
+	if len(e.Line) == 0 {
+		return fmt.Sprintf("%s:%s: %s", e.File, e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s:%s: %s\n\t%s\n\t%s^", e.File, e.Pos, e.Msg, e.Line, caretIndent(e.Line, e.Pos.Col))
+}
+
+// caretIndent returns the whitespace placing a caret under column col of
+// line, keeping tabs so the caret lines up however tabs are rendered.
+func caretIndent(line string, col int) string {
+	var buf bytes.Buffer
+	for i, r := range []rune(line) {
+		if i >= col-1 {
+			break
+		}
+		if r == '\t' {
+			buf.WriteRune('\t')
+		} else {
+			buf.WriteRune(' ')
+		}
+	}
+	return buf.String()
+}
+
 type Parser struct {
-	t *Tokenizer
-	f *os.File
+	name string
+	src  []byte
+	toks []Token
+	i    int
 }
 
+// ParseJobsInDirectory loads and compiles every job below the directory path.
+// Jobs that fail to load are left out and their errors returned together as
+// an ErrorList alongside the jobs that did load.
 func ParseJobsInDirectory(path string) (jobs []*core.RPCJob, err error) {
-	var jobPath string
-
-	if filepath.IsAbs(path) == false {
-		jobPath = path
-	} else {
-		jobPath, err = filepath.Abs(path)
+	asts, err := ParseDirectory(path)
+	errs, _ := err.(ErrorList)
+	if err != nil && errs == nil {
+		return
+	}
+	for _, ast := range asts {
+		job, err := Compile(ast)
 		if err != nil {
-			return
+			errs = append(errs, err)
+			continue
 		}
+		log.Printf("loaded job: %s\n", job.Name)
+		jobs = append(jobs, job)
 	}
+	return jobs, errs.Err()
+}
 
+// ParseDirectory parses every job file below the directory path, see
+// JobFiles. Files that fail to parse are reported in an ErrorList, the jobs
+// of all other files are still returned.
+func ParseDirectory(path string) (jobs []*Job, err error) {
 	var files []string
-	files, err = filepath.Glob(filepath.Join(jobPath, "*.job"))
-
+	if files, err = JobFiles(path); err != nil {
+		return
+	}
+	var errs ErrorList
 	for _, f := range files {
-		var fd *os.File
-		var job *core.RPCJob
+		expanded, err := LoadFile(f, path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		jobs = append(jobs, expanded...)
+	}
+	return jobs, errs.Err()
+}
 
-		if fd, err = os.Open(f); err != nil {
+// LoadFile parses the job file at path and expands it into the jobs it
+// stands for, see Expand. root is the jobs directory, the job is named after
+// its path relative to root and imports are resolved from there.
+func LoadFile(path, root string) ([]*Job, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	job, err := Parse(JobName(path, root), src)
+	if err != nil {
+		return nil, err
+	}
+	return Expand(job, root)
+}
+
+// ParseFile parses the job file at path, naming the job after the file.
+func ParseFile(path string) (*Job, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filepath.Base(path), src)
+}
+
+// Parse parses the job source src. Syntax errors are returned as *ParseError.
+func Parse(name string, src []byte) (*Job, error) {
+	p := &Parser{
+		name: name,
+		src:  src,
+	}
+	t := NewTokenizer(bufio.NewReader(bytes.NewReader(src)))
+	go t.Tokenize()
+	for tok := range t.C {
+		p.toks = append(p.toks, tok)
+	}
+	return p.parseJob()
+}
+
+// Compile converts a job's syntax tree into the form dispatched to runners.
+func Compile(ast *Job) (job *core.RPCJob, err error) {
+	if len(ast.Imports) > 0 {
+		imp := ast.Imports[0]
+		err = fmt.Errorf("%s:%s: import %q has not been resolved", ast.Name, imp.Pos, imp.Path)
+		return
+	}
+	job = &core.RPCJob{
+		Name: ast.Name,
+	}
+	var block *Resource
+	for _, r := range ast.Resources {
+		if r.Provider == JobProvider {
+			if block != nil {
+				err = fmt.Errorf("%s:%s: job block already declared at %s:%s", r.File, r.Pos, block.File, block.Pos)
+				return
+			}
+			block = r
+			var rest map[string]interface{}
+			if rest, err = splitOptions(r, JobOptionsSchema, &job.Options); err != nil {
+				return
+			}
+			for name := range rest {
+				err = fmt.Errorf("%s:%s: unknown job option %q", r.File, r.Pos, name)
+				return
+			}
+			continue
+		}
+		var obj core.ParseObject
+		if obj, err = compileResource(r); err != nil {
 			return
 		}
-		p := &Parser{
-			f: fd,
-			t: NewTokenizer(bufio.NewReader(fd)),
+		job.Objects = append(job.Objects, obj)
+	}
+	var deps [][]int
+	if deps, err = jobDependencies(ast, job.Options.Parallel); err != nil {
+		return
+	}
+	for i, indices := range deps {
+		job.Objects[i].Options.After = indices
+	}
+	for _, sec := range ast.Sections {
+		var objects []core.ParseObject
+		for _, r := range sec.Resources {
+			var obj core.ParseObject
+			if obj, err = compileResource(r); err != nil {
+				return
+			}
+			objects = append(objects, obj)
 		}
-		job, err = p.parse()
-		if err != nil {
-			return
+		switch sec.Name {
+		case OnFailureSection:
+			job.OnFailure = objects
+		case FinallySection:
+			job.Finally = objects
 		}
-		log.Printf("loaded job: %s\n", job.Name)
-		jobs = append(jobs, job)
 	}
+	job.Hash = core.HashJob(job)
 	return
 }
 
-func (p *Parser) parse() (job *core.RPCJob, err error) {
-	var obj []core.ParseObject
-
-	err = json.Unmarshal(p.toJson(), &obj)
+func compileResource(r *Resource) (obj core.ParseObject, err error) {
+	if r.Provider == ParamProvider || r.Provider == InstanceProvider {
+		err = fmt.Errorf("%s:%s: %s declarations must be expanded before compiling", r.File, r.Pos, r.Provider)
+		return
+	}
+	var options core.TaskOptions
+	var properties map[string]interface{}
+	if properties, err = splitOptions(r, TaskOptionsSchema, &options); err != nil {
+		return
+	}
+	var b []byte
+	b, err = json.Marshal(properties)
 	if err != nil {
-		err = fmt.Errorf("Error parsing json data.. %v\n", err)
+		err = fmt.Errorf("%s:%s: encoding properties of %s: %v", r.File, r.Pos, r.Title, err)
 		return
 	}
-	job = &core.RPCJob{
-		Name:    filepath.Base(p.f.Name()),
-		Objects: obj,
+	obj = core.ParseObject{
+		Provider:   r.Provider,
+		Name:       r.Title,
+		Properties: b,
+		Options:    options,
 	}
 	return
 }
 
-func (p *Parser) toJson() []byte {
-	go p.t.Tokenize()
+func (p *Parser) next() Token {
+	if p.i >= len(p.toks) {
+		return Token{typ: tEOF}
+	}
+	t := p.toks[p.i]
+	p.i++
+	return t
+}
 
-	var buf bytes.Buffer
+// errorf returns a *ParseError positioned at pos.
+func (p *Parser) errorf(pos Pos, format string, args ...interface{}) error {
+	e := &ParseError{
+		File: p.name,
+		Pos:  pos,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+	lines := strings.Split(string(p.src), "\n")
+	if pos.Line > 0 && pos.Line <= len(lines) {
+		e.Line = strings.TrimRight(lines[pos.Line-1], "\r")
+	}
+	return e
+}
+
+// unexpected reports t where something else was expected, passing through the
+// tokenizer's own message for tError tokens.
+func (p *Parser) unexpected(t Token, expecting string) error {
+	switch t.typ {
+	case tError:
+		return p.errorf(t.pos, "%s", t.val)
+	case tEOF:
+		return p.errorf(t.pos, "unexpected end of file, expecting %s", expecting)
+	}
+	return p.errorf(t.pos, "unexpected %s, expecting %s", describe(t), expecting)
+}
 
-	var providerCount int
-	var propertyCount int
-	var propertyArrayCount int
-	var propertyMapCount int
+func describe(t Token) string {
+	switch t.typ {
+	case tOpenBrace, tCloseBrace, tOpenBracket, tCloseBracket:
+		return t.val
+	case tComment:
+		return "comment"
+	}
+	return fmt.Sprintf("%q", t.val)
+}
 
-	buf.WriteByte('[')
-Loop:
+func (p *Parser) parseJob() (*Job, error) {
+	job := &Job{Name: p.name}
+	var comments []*Comment
 	for {
-		select {
-		case t, ok := <-p.t.C:
-			if !ok {
-				break Loop
+		t := p.next()
+		switch t.typ {
+		case tEOF:
+			job.Comments = comments
+			return job, nil
+		case tComment:
+			comments = append(comments, &Comment{Pos: t.pos, Text: t.val})
+		case tImport:
+			path := p.next()
+			if path.typ != tImportPath {
+				return nil, p.unexpected(path, "quoted import path")
 			}
-			switch t.typ {
-			case tProvider:
-				if propertyCount > 0 {
-					buf.WriteString(`}]`)
-					propertyCount = 0
-				}
-				if propertyMapCount > 0 {
-					buf.WriteString(`}}]`)
-					propertyMapCount = 0
-				}
-				if propertyArrayCount > 0 {
-					buf.WriteString(`]}]`)
-					propertyArrayCount = 0
-				}
-				if providerCount > 0 {
-					buf.WriteString(`},`)
-				}
-				buf.WriteString(fmt.Sprintf(`{"provider":"%s",`, core.JSONEscape(t.val)))
-				providerCount++
-			case tResourceTitle:
-				buf.WriteString(fmt.Sprintf(`"name":"%s",`, core.JSONEscape(t.val)))
-			case tResourcePropertyName:
-				if propertyCount == 0 &&
-					propertyArrayCount == 0 &&
-					propertyMapCount == 0 {
-					buf.WriteString(`"properties":[`)
-				}
-				if propertyCount > 0 {
-					buf.WriteString(`},`)
-					propertyCount = 0
-				}
-				if propertyArrayCount > 0 {
-					buf.WriteString(`]},`)
-					propertyArrayCount = 0
-				}
-				if propertyMapCount > 0 {
-					buf.WriteString(`}},`)
-					propertyMapCount = 0
-				}
-				buf.WriteString(fmt.Sprintf(`{"%s":`, core.JSONEscape(t.val)))
-			case tResourcePropertyValue:
-				buf.WriteString(fmt.Sprintf(`"%s"`, core.JSONEscape(t.val)))
-				propertyCount++
-			case tResourcePropertyArrayValue:
-				if propertyArrayCount == 0 {
-					buf.WriteByte('[')
-				} else {
-					buf.WriteByte(',')
-				}
-				buf.WriteString(fmt.Sprintf(`"%s"`, core.JSONEscape(t.val)))
-				propertyArrayCount++
-			case tResourcePropertyMapName:
-				if propertyMapCount == 0 {
-					buf.WriteByte('{')
-				} else {
-					buf.WriteByte(',')
-				}
-				buf.WriteString(fmt.Sprintf(`"%s":`, core.JSONEscape(t.val)))
-			case tResourcePropertyMapValue:
-				buf.WriteString(fmt.Sprintf(`"%s"`, core.JSONEscape(t.val)))
-				propertyMapCount++
+			job.Imports = append(job.Imports, &Import{
+				Pos:      t.pos,
+				Path:     path.val,
+				Index:    len(job.Resources),
+				Comments: comments,
+			})
+			comments = nil
+		case tProvider:
+			r, err := p.parseResource(t)
+			if err != nil {
+				return nil, err
+			}
+			r.Comments = comments
+			comments = nil
+			job.Resources = append(job.Resources, r)
+		case tSection:
+			if s := job.Section(t.val); s != nil {
+				return nil, p.errorf(t.pos, "%s already declared at %s", t.val, s.Pos)
+			}
+			s, err := p.parseSection(t)
+			if err != nil {
+				return nil, err
+			}
+			s.Comments = comments
+			comments = nil
+			job.Sections = append(job.Sections, s)
+		default:
+			return nil, p.unexpected(t, "provider, import or comment")
+		}
+	}
+}
+
+func (p *Parser) parseSection(name Token) (*Section, error) {
+	s := &Section{
+		Pos:  name.pos,
+		Name: name.val,
+	}
+	if t := p.next(); t.typ != tOpenBrace {
+		return nil, p.unexpected(t, "{")
+	}
+	var comments []*Comment
+	for {
+		t := p.next()
+		switch t.typ {
+		case tCloseBrace:
+			s.End = t.pos
+			s.Trailing = comments
+			return s, nil
+		case tComment:
+			comments = append(comments, &Comment{Pos: t.pos, Text: t.val})
+		case tProvider:
+			if t.val == JobProvider {
+				return nil, p.errorf(t.pos, "job block cannot be declared inside %s", s.Name)
 			}
+			r, err := p.parseResource(t)
+			if err != nil {
+				return nil, err
+			}
+			r.Comments = comments
+			comments = nil
+			s.Resources = append(s.Resources, r)
+		case tSection:
+			return nil, p.errorf(t.pos, "%s cannot be declared inside %s", t.val, s.Name)
+		default:
+			return nil, p.unexpected(t, fmt.Sprintf("provider, comment or } to close %s", s.Name))
 		}
 	}
+}
 
-	if propertyCount > 0 {
-		buf.WriteString(`}]`)
-		propertyCount = 0
+func (p *Parser) parseResource(provider Token) (*Resource, error) {
+	r := &Resource{
+		File:     p.name,
+		Pos:      provider.pos,
+		Provider: provider.val,
 	}
-	if propertyMapCount > 0 {
-		buf.WriteString(`}}]`)
-		propertyMapCount = 0
+	t := p.next()
+	switch {
+	case r.Provider == JobProvider && t.typ == tResourceTitle:
+		return nil, p.errorf(t.pos, "job block takes no title, expecting {")
+	case r.Provider == JobProvider:
+	case t.typ != tResourceTitle:
+		return nil, p.unexpected(t, "resource title")
+	default:
+		r.Title = t.val
+		t = p.next()
 	}
-	if propertyArrayCount > 0 {
-		buf.WriteString(`]}]`)
-		propertyArrayCount = 0
+	if t.typ != tOpenBrace {
+		return nil, p.unexpected(t, "{")
 	}
-	if providerCount > 0 {
-		buf.WriteByte('}')
+	var comments []*Comment
+	for {
+		t = p.next()
+		switch t.typ {
+		case tCloseBrace:
+			r.End = t.pos
+			r.Trailing = comments
+			return r, nil
+		case tComment:
+			comments = append(comments, &Comment{Pos: t.pos, Text: t.val})
+		case tResourcePropertyName:
+			if len(t.val) == 0 {
+				return nil, p.errorf(t.pos, "missing property name before :")
+			}
+			v, err := p.parseValue(p.next())
+			if err != nil {
+				return nil, err
+			}
+			r.Properties = append(r.Properties, &Property{
+				Pos:      t.pos,
+				Name:     t.val,
+				Value:    v,
+				Comments: comments,
+			})
+			comments = nil
+		default:
+			return nil, p.unexpected(t, strings.TrimSpace(fmt.Sprintf("property name or } to close %s %s", r.Provider, r.Title)))
+		}
+	}
+}
+
+// parseValue returns the scalar, array or map starting with token t.
+func (p *Parser) parseValue(t Token) (Value, error) {
+	switch t.typ {
+	case tResourcePropertyValue, tResourcePropertyArrayValue, tResourcePropertyMapValue:
+		return &Scalar{Pos: t.pos, Text: t.val, Style: t.style, Delim: t.delim}, nil
+	case tOpenBracket:
+		arr := &Array{Pos: t.pos}
+		var comments []*Comment
+		for {
+			e := p.next()
+			switch e.typ {
+			case tCloseBracket:
+				arr.End = e.pos
+				arr.Trailing = comments
+				return arr, nil
+			case tComment:
+				comments = append(comments, &Comment{Pos: e.pos, Text: e.val})
+				continue
+			case tEOF, tError:
+				return nil, p.unexpected(e, "array value or ]")
+			}
+			v, err := p.parseValue(e)
+			if err != nil {
+				return nil, err
+			}
+			arr.Elems = append(arr.Elems, v)
+			arr.Comments = append(arr.Comments, comments)
+			comments = nil
+		}
+	case tOpenBrace:
+		m := &Map{Pos: t.pos}
+		var comments []*Comment
+		for {
+			e := p.next()
+			switch e.typ {
+			case tCloseBrace:
+				m.End = e.pos
+				m.Trailing = comments
+				return m, nil
+			case tComment:
+				comments = append(comments, &Comment{Pos: e.pos, Text: e.val})
+			case tResourcePropertyMapName:
+				v, err := p.parseValue(p.next())
+				if err != nil {
+					return nil, err
+				}
+				m.Entries = append(m.Entries, &Property{
+					Pos:      e.pos,
+					Name:     e.val,
+					Value:    v,
+					Comments: comments,
+				})
+				comments = nil
+			default:
+				return nil, p.unexpected(e, "map entry or }")
+			}
+		}
 	}
-	buf.WriteByte(']')
-	return buf.Bytes()
+	return nil, p.unexpected(t, "value")
 }
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// properties parses src and returns the JSON of the properties of its first
+// resource.
+func properties(t *testing.T, src string) string {
+	t.Helper()
+	job, err := Parse("test.job", []byte(src))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(job.Resources) == 0 {
+		t.Fatal("Parse returned no resources")
+	}
+	props := make(map[string]interface{})
+	for _, p := range job.Resources[0].Properties {
+		props[p.Name] = p.Value.Interface()
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseNestedValues(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "array of strings",
+			src: `localexec Run {
+	Args:[
+		-c
+		"two words"
+	]
+}`,
+			want: `{"Args":["-c","two words"]}`,
+		},
+		{
+			name: "empty containers",
+			src: `localexec Run {
+	Args:[]
+	Env:{}
+}`,
+			want: `{"Args":[],"Env":{}}`,
+		},
+		{
+			name: "pipeline",
+			src: `mongo ActiveNodes {
+	Pipeline:[
+		{
+			$match:{
+				status: active
+				tags:[
+					a
+					b
+				]
+			}
+		}
+		{
+			$limit: 10
+		}
+	]
+}`,
+			want: `{"Pipeline":[{"$match":{"status":"active","tags":["a","b"]}},{"$limit":10}]}`,
+		},
+		{
+			name: "arrays of arrays",
+			src: `x Y {
+	Matrix:[
+		[
+			1
+			2
+		]
+		[]
+	]
+}`,
+			want: `{"Matrix":[[1,2],[]]}`,
+		},
+		{
+			name: "heredoc inside a map",
+			src: `x Y {
+	Files:{
+		run.sh: <<EOF
+echo hi
+EOF
+		name: "quoted: value"
+	}
+}`,
+			want: `{"Files":{"name":"quoted: value","run.sh":"echo hi"}}`,
+		},
+		{
+			name: "comments inside containers",
+			src: `x Y {
+	Query:{ // note
+		// the status
+		status: active
+		// trailing
+	}
+	Args:[
+		// first
+		-c
+		/usr/bin
+		// trailing
+	]
+}`,
+			want: `{"Args":["-c","/usr/bin"],"Query":{"status":"active"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := properties(t, tt.src); got != tt.want {
+				t.Errorf("properties = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseValuePositions(t *testing.T) {
+	job, err := Parse("test.job", []byte("x Y {\n\tList:[\n\t\t{\n\t\t\tk: v\n\t\t}\n\t]\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := job.Resources[0].Property("List").Value.(*Array)
+	m := list.Elems[0].(*Map)
+	if list.Pos != (Pos{Line: 2, Col: 7}) || list.End != (Pos{Line: 6, Col: 2}) {
+		t.Errorf("array at %s to %s", list.Pos, list.End)
+	}
+	if m.Pos != (Pos{Line: 3, Col: 3}) || m.Entries[0].Value.Position() != (Pos{Line: 4, Col: 7}) {
+		t.Errorf("map at %s, entry at %s", m.Pos, m.Entries[0].Value.Position())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"end of file", "mongo RazorNodes {\n\tDatabase: razor\n", "test.job:2:17: unexpected end of file, expecting property name or } to close mongo RazorNodes"},
+		{"missing title", "mongo {\n}\n", "test.job:1:7: unexpected {, expecting resource title"},
+		{"job title", "job settings {\n}\n", "test.job:1:5: job block takes no title, expecting {"},
+		{"missing name", "x Y {\n\t: v\n}\n", "test.job:2:2: missing property name before :"},
+		{"tokenizer error", "x Y {\n\tA: \"open\n}\n", "test.job:2:10: Unterminated quoted string"},
+		{"quoted map key", "x Y {\n\tQ:{\n\t\t\"quoted key\": 1\n\t}\n}\n", "test.job:3:11: Invalid character read when expecting colon after map key \"\\\"quoted\", names cannot contain whitespace [char: 'k']"},
+		{"property name with space", "x Y {\n\tTwo words: 1\n}\n", "test.job:2:6: Invalid character read when expecting colon after property name \"Two\", names cannot contain whitespace [char: 'w']"},
+		{"inline map", "x Y {\n\tQ:{ a: 1 }\n}\n", "test.job:2:6: Invalid character read after {, entries start on the next line [char: 'a']"},
+		{"inline array", "x Y {\n\tA:[a]\n}\n", "test.job:2:5: Invalid character read after [, entries start on the next line [char: 'a']"},
+		{"unclosed array", "x Y {\n\tA:[\n\t\tv\n", "test.job:3:4: unexpected end of file, expecting array value or ]"},
+		{"section twice", "finally {\n}\nfinally {\n}\n", "test.job:3:1: finally already declared at 1:1"},
+		{"job in section", "on_failure {\n\tjob {\n\t}\n}\n", "test.job:2:2: job block cannot be declared inside on_failure"},
+		{"nested section", "finally {\n\ton_failure {\n\t}\n}\n", "test.job:2:2: on_failure cannot be declared inside finally"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("test.job", []byte(tt.src))
+			perr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("error = %v, want a *ParseError", err)
+			}
+			if msg := fmt.Sprintf("%s:%s: %s", perr.File, perr.Pos, perr.Msg); msg != tt.err {
+				t.Errorf("error = %s, want %s", msg, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	_, err := Parse("test.job", []byte("x Y {\n\tA: \"x\" y\n}\n"))
+	want := "test.job:2:9: Unexpected character after quoted string [char: 'y']\n\t\tA: \"x\" y\n\t\t       ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
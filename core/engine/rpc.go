@@ -120,7 +120,7 @@ func NewRPCClient(min, max int, endpoint string, tlsConfig *tls.Config) (clt *RP
 	return
 }
 
-func (r RPCClient) Call(method string, args interface{}, reply interface{}) (err error) {
+func (r *RPCClient) Call(method string, args interface{}, reply interface{}) (err error) {
 	// Retry on : io.ErrUnexpectedEOF
 	// Cache on : rpc.ErrShutdown
 	err = ErrorZeroAttempts
@@ -133,11 +133,11 @@ func (r RPCClient) Call(method string, args interface{}, reply interface{}) (err
 	return
 }
 
-func (r RPCClient) Endpoint() string {
+func (r *RPCClient) Endpoint() string {
 	return r.endpoint
 }
 
-func (r RPCClient) Ready() bool {
+func (r *RPCClient) Ready() bool {
 	r.muPing.Lock()
 	lastPong := r.lastPong
 	errors := r.errors
@@ -154,7 +154,7 @@ func (r RPCClient) Ready() bool {
 	return true
 }
 
-func (r RPCClient) Heartbeat() {
+func (r *RPCClient) Heartbeat() {
 	r.ticker = time.NewTicker(1 * time.Second)
 	request := []byte("Ping!")
 	for {
@@ -178,7 +178,7 @@ func (r RPCClient) Heartbeat() {
 	}
 }
 
-func (r RPCClient) Close() {
+func (r *RPCClient) Close() {
 	close(r.quit)
 	r.pool.Close()
 }
@@ -188,7 +188,8 @@ type RPCMgr struct {
 	Clients      []*RPCClient
 	ReadyClients []*RPCClient
 
-	Assignments map[*RPCClient][]*core.RPCJob
+	muAssignments sync.Mutex
+	Assignments   map[*RPCClient][]*core.RPCJob
 
 	muNextClient sync.Mutex
 	nextClient   int
@@ -247,7 +248,11 @@ func (mgr *RPCMgr) MoveJobs(client *RPCClient) {
 			mgr.muClients.Unlock()
 		}
 	}
-	for _, assignment := range mgr.Assignments[client] {
+	mgr.muAssignments.Lock()
+	assignments := mgr.Assignments[client]
+	delete(mgr.Assignments, client)
+	mgr.muAssignments.Unlock()
+	for _, assignment := range assignments {
 		mgr.DispatchJob(assignment)
 	}
 }
@@ -264,7 +269,92 @@ func (mgr *RPCMgr) DispatchJob(job *core.RPCJob) (err error) {
 		log.Printf("Failed to dispatch job %s -> %v\n", job.Name, err)
 		return
 	}
+	mgr.muAssignments.Lock()
 	mgr.Assignments[client] = append(mgr.Assignments[client], job)
+	mgr.muAssignments.Unlock()
+	return
+}
+
+// assignment returns the client job name was dispatched to and its index in
+// the client's assignments, mgr.muAssignments must be held.
+func (mgr *RPCMgr) assignment(name string) (*RPCClient, int) {
+	for c, jobs := range mgr.Assignments {
+		for i, j := range jobs {
+			if j.Name == name {
+				return c, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// WithdrawJob stops the job called name on the runner it was dispatched to.
+func (mgr *RPCMgr) WithdrawJob(name string) (err error) {
+	mgr.muAssignments.Lock()
+	client, _ := mgr.assignment(name)
+	mgr.muAssignments.Unlock()
+	if client == nil {
+		return
+	}
+	var buf []byte
+	err = client.Call("RPCTask.Undispatch", &name, &buf)
+	if err != nil {
+		log.Printf("Failed to withdraw job %s -> %v\n", name, err)
+		return
+	}
+	mgr.muAssignments.Lock()
+	if c, i := mgr.assignment(name); c == client {
+		mgr.Assignments[client] = append(mgr.Assignments[client][:i], mgr.Assignments[client][i+1:]...)
+	}
+	mgr.muAssignments.Unlock()
+	return
+}
+
+// ReplaceJob swaps a changed job for the version of the same name running on
+// its runner, jobs that were not dispatched yet are dispatched.
+func (mgr *RPCMgr) ReplaceJob(job *core.RPCJob) (err error) {
+	mgr.muAssignments.Lock()
+	client, _ := mgr.assignment(job.Name)
+	mgr.muAssignments.Unlock()
+	if client == nil {
+		return mgr.DispatchJob(job)
+	}
+	var buf []byte
+	err = client.Call("RPCTask.Replace", job, &buf)
+	if err != nil {
+		log.Printf("Failed to replace job %s -> %v\n", job.Name, err)
+		return
+	}
+	mgr.muAssignments.Lock()
+	if c, i := mgr.assignment(job.Name); c == client {
+		mgr.Assignments[client][i] = job
+	}
+	mgr.muAssignments.Unlock()
+	return
+}
+
+// ApplyChanges brings the runners in line with changes reported by a
+// Watcher, jobs that did not change are left alone. It returns the changes
+// that succeeded, the others are left for the Watcher to report again.
+func (mgr *RPCMgr) ApplyChanges(changes JobChanges) (applied JobChanges) {
+	for _, name := range changes.Removed {
+		log.Printf("withdrawing job: %s\n", name)
+		if mgr.WithdrawJob(name) == nil {
+			applied.Removed = append(applied.Removed, name)
+		}
+	}
+	for _, job := range changes.Changed {
+		log.Printf("replacing job: %s\n", job.Name)
+		if mgr.ReplaceJob(job) == nil {
+			applied.Changed = append(applied.Changed, job)
+		}
+	}
+	for _, job := range changes.Added {
+		log.Printf("dispatching job: %s\n", job.Name)
+		if mgr.DispatchJob(job) == nil {
+			applied.Added = append(applied.Added, job)
+		}
+	}
 	return
 }
 
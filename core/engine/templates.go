@@ -0,0 +1,285 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// Reserved provider names used to declare template parameters and to
+// instantiate templates:
+//
+//	param Collection {
+//		Default: nodes
+//	}
+//
+//	instance RazorNodes {
+//		Template: templates/latest.job
+//		Values: values/razor.json
+//		Params:{
+//			Collection: nodes
+//		}
+//	}
+const (
+	ParamProvider    = "param"
+	InstanceProvider = "instance"
+)
+
+// IsTemplate reports whether job declares parameters. Templates are only
+// dispatched through instances, never on their own.
+func (job *Job) IsTemplate() bool {
+	for _, r := range job.Resources {
+		if r.Provider == ParamProvider {
+			return true
+		}
+	}
+	return false
+}
+
+// Instances returns the instance declarations of job.
+func (job *Job) Instances() (instances []*Resource) {
+	for _, r := range job.Resources {
+		if r.Provider == InstanceProvider {
+			instances = append(instances, r)
+		}
+	}
+	return
+}
+
+// Expand resolves the imports of job and returns the jobs it stands for: none
+// for a template, one per instance for a file of instances, and the job
+// itself otherwise.
+func Expand(job *Job, root string) ([]*Job, error) {
+	if err := ResolveImports(job, root); err != nil {
+		return nil, err
+	}
+	if job.IsTemplate() {
+		return nil, nil
+	}
+	instances := job.Instances()
+	if len(instances) == 0 {
+		return []*Job{job}, nil
+	}
+	if len(job.Sections) > 0 {
+		s := job.Sections[0]
+		return nil, &ParseError{File: job.Name, Pos: s.Pos, Msg: fmt.Sprintf("%s cannot be declared in a file of instances, declare it in the template", s.Name)}
+	}
+	if len(instances) != len(job.Resources) {
+		for _, r := range job.Resources {
+			if r.Provider != InstanceProvider {
+				return nil, &ParseError{File: r.File, Pos: r.Pos, Msg: "instances cannot be mixed with other resources in one job file"}
+			}
+		}
+	}
+	var jobs []*Job
+	for _, inst := range instances {
+		j, err := Instantiate(job.Name, inst, root)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Instantiate builds the job described by the instance declaration inst
+// found in the job file name. The job is called name:<instance title>.
+func Instantiate(name string, inst *Resource, root string) (*Job, error) {
+	errorf := func(pos Pos, format string, args ...interface{}) error {
+		return &ParseError{File: inst.File, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	tp := inst.Property("Template")
+	if tp == nil {
+		return nil, errorf(inst.Pos, "instance %s is missing required property Template", inst.Title)
+	}
+	ts, ok := tp.Value.(*Scalar)
+	if !ok {
+		return nil, errorf(tp.Pos, "property Template must be a path")
+	}
+	tmplPath, ok := jobsPath(ts.Text)
+	if !ok {
+		return nil, errorf(ts.Pos, "template %q must be relative to the jobs directory", ts.Text)
+	}
+
+	values := make(map[string]string)
+	if vp := inst.Property("Values"); vp != nil {
+		vs, ok := vp.Value.(*Scalar)
+		if !ok {
+			return nil, errorf(vp.Pos, "property Values must be a path")
+		}
+		valuesPath, ok := jobsPath(vs.Text)
+		if !ok {
+			return nil, errorf(vs.Pos, "values %q must be relative to the jobs directory", vs.Text)
+		}
+		if err := readValues(filepath.Join(root, filepath.FromSlash(valuesPath)), values); err != nil {
+			return nil, errorf(vs.Pos, "reading values %q: %v", vs.Text, err)
+		}
+	}
+	if pp := inst.Property("Params"); pp != nil {
+		m, ok := pp.Value.(*Map)
+		if !ok {
+			return nil, errorf(pp.Pos, "property Params must be a map")
+		}
+		for _, e := range m.Entries {
+			s, ok := e.Value.(*Scalar)
+			if !ok {
+				return nil, errorf(e.Pos, "parameter %s must be a single value", e.Name)
+			}
+			values[e.Name] = s.Text
+		}
+	}
+	for _, p := range inst.Properties {
+		switch p.Name {
+		case "Template", "Values", "Params":
+		default:
+			return nil, errorf(p.Pos, "unknown property %q for instance, expecting Template, Values or Params", p.Name)
+		}
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(tmplPath)))
+	if err != nil {
+		return nil, errorf(ts.Pos, "reading template %q: %v", ts.Text, err)
+	}
+	tmpl, err := Parse(tmplPath, src)
+	if err != nil {
+		return nil, err
+	}
+	if err = ResolveImports(tmpl, root); err != nil {
+		return nil, err
+	}
+	if !tmpl.IsTemplate() {
+		return nil, errorf(ts.Pos, "%s is not a template, it declares no params", ts.Text)
+	}
+
+	params, err := templateParams(tmpl, values)
+	if err != nil {
+		return nil, errorf(inst.Pos, "instance %s: %v", inst.Title, err)
+	}
+
+	job := &Job{
+		Name:     fmt.Sprintf("%s:%s", name, inst.Title),
+		Sections: tmpl.Sections,
+		Comments: tmpl.Comments,
+	}
+	for _, r := range tmpl.Resources {
+		if r.Provider == ParamProvider {
+			continue
+		}
+		if err = substituteResource(r, params); err != nil {
+			return nil, err
+		}
+		job.Resources = append(job.Resources, r)
+	}
+	for _, s := range tmpl.Sections {
+		for _, r := range s.Resources {
+			if err = substituteResource(r, params); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return job, nil
+}
+
+func substituteResource(r *Resource, params map[string]string) error {
+	for _, p := range r.Properties {
+		if err := substitute(p.Value, params); err != nil {
+			return &ParseError{File: r.File, Pos: p.Value.Position(), Msg: err.Error()}
+		}
+	}
+	return nil
+}
+
+// templateParams merges values with the defaults declared by tmpl, failing
+// on values for undeclared parameters and on required parameters left unset.
+func templateParams(tmpl *Job, values map[string]string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, r := range tmpl.Resources {
+		if r.Provider != ParamProvider {
+			continue
+		}
+		if d := r.Property("Default"); d != nil {
+			s, ok := d.Value.(*Scalar)
+			if !ok {
+				return nil, fmt.Errorf("default of parameter %s must be a single value", r.Title)
+			}
+			params[r.Title] = s.Text
+		}
+		if v, ok := values[r.Title]; ok {
+			params[r.Title] = v
+		}
+		if _, ok := params[r.Title]; !ok {
+			return nil, fmt.Errorf("missing value for required parameter %s", r.Title)
+		}
+	}
+	for name := range values {
+		if _, ok := params[name]; !ok {
+			return nil, fmt.Errorf("%s declares no parameter %s", tmpl.Name, name)
+		}
+	}
+	return params, nil
+}
+
+// substitute replaces ${param:name} references in every scalar of value.
+func substitute(value Value, params map[string]string) error {
+	switch v := value.(type) {
+	case *Array:
+		for _, e := range v.Elems {
+			if err := substitute(e, params); err != nil {
+				return err
+			}
+		}
+	case *Map:
+		for _, e := range v.Entries {
+			if err := substitute(e.Value, params); err != nil {
+				return err
+			}
+		}
+	case *Scalar:
+		text, err := core.ExpandReferences(v.Text, func(scheme, name string) (string, bool, error) {
+			if scheme != "param" {
+				return "", false, nil
+			}
+			value, ok := params[name]
+			if !ok {
+				return "", false, fmt.Errorf("reference to undeclared parameter %s", name)
+			}
+			return value, true, nil
+		})
+		if err != nil {
+			return err
+		}
+		v.Text = text
+	}
+	return nil
+}
+
+// readValues reads a JSON object of parameter values from path into values.
+func readValues(path string, values map[string]string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&raw); err != nil {
+		return err
+	}
+	for k, v := range raw {
+		switch v.(type) {
+		case []interface{}, map[string]interface{}:
+			return fmt.Errorf("value of %s must be a single value", k)
+		case nil:
+			values[k] = ""
+		default:
+			values[k] = strings.TrimSpace(fmt.Sprint(v))
+		}
+	}
+	return nil
+}
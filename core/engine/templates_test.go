@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+const latestTemplate = `param Limit {
+	Default: 5
+}
+
+param Database {
+}
+
+param Collection {
+	Default: nodes
+}
+
+mongo Latest {
+	Database: ${param:Database}
+	Collection: "${param:Collection}"
+	Pipeline:[
+		{
+			$limit: ${param:Limit}
+		}
+	]
+}
+
+finally {
+	localexec Log {
+		File: echo
+		Args:[
+			${param:Database}.${param:Collection}
+		]
+	}
+}
+`
+
+func TestExpand(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"templates/latest.job": latestTemplate,
+		"templates/plain.job":  razorFragment,
+		"templates/undeclared.job": `param A {
+}
+
+x Y {
+	B: ${param:B}
+}
+`,
+		"values/razor.json": `{"Database": "razor", "Limit": 10}`,
+		"values/list.json":  `{"Database": ["a", "b"]}`,
+	})
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		name string
+		src  string
+		jobs []string
+		err  string
+	}{
+		{
+			name: "template",
+			src:  latestTemplate,
+		},
+		{
+			name: "plain job",
+			src:  razorFragment,
+			jobs: []string{"test.job Database=razor Collection=nodes"},
+		},
+		{
+			name: "params and defaults",
+			src: `instance Razor {
+	Template: templates/latest.job
+	Params:{
+		Database: razor
+	}
+}
+`,
+			jobs: []string{"test.job:Razor Database=razor Collection=nodes Pipeline=[{\"$limit\":5}] Log.Args=[\"razor.nodes\"]"},
+		},
+		{
+			name: "params override values",
+			src: `instance Razor {
+	Template: templates/latest.job
+	Values: values/razor.json
+}
+
+instance Archive {
+	Template: templates/latest.job
+	Values: values/razor.json
+	Params:{
+		Collection: archive
+	}
+}
+`,
+			jobs: []string{
+				"test.job:Razor Database=razor Collection=nodes Pipeline=[{\"$limit\":10}] Log.Args=[\"razor.nodes\"]",
+				"test.job:Archive Database=razor Collection=archive Pipeline=[{\"$limit\":10}] Log.Args=[\"razor.archive\"]",
+			},
+		},
+		{
+			name: "missing required parameter",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n}\n",
+			err:  "test.job:1:1: instance Razor: missing value for required parameter Database",
+		},
+		{
+			name: "undeclared parameter value",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n\tParams:{\n\t\tDatabase: razor\n\t\tColor: red\n\t}\n}\n",
+			err:  "test.job:1:1: instance Razor: templates/latest.job declares no parameter Color",
+		},
+		{
+			name: "undeclared parameter reference",
+			src:  "instance Y {\n\tTemplate: templates/undeclared.job\n\tParams:{\n\t\tA: a\n\t}\n}\n",
+			err:  "templates/undeclared.job:5:5: reference to undeclared parameter B",
+		},
+		{
+			name: "not a template",
+			src:  "instance Razor {\n\tTemplate: templates/plain.job\n}\n",
+			err:  "test.job:2:12: templates/plain.job is not a template, it declares no params",
+		},
+		{
+			name: "missing template",
+			src:  "instance Razor {\n\tTemplate: templates/missing.job\n}\n",
+			err:  `test.job:2:12: reading template "templates/missing.job"`,
+		},
+		{
+			name: "template outside the root",
+			src:  "instance Razor {\n\tTemplate: ../templates/latest.job\n}\n",
+			err:  `test.job:2:12: template "../templates/latest.job" must be relative to the jobs directory`,
+		},
+		{
+			name: "absolute template",
+			src:  "instance Razor {\n\tTemplate: /etc/passwd\n}\n",
+			err:  `test.job:2:12: template "/etc/passwd" must be relative to the jobs directory`,
+		},
+		{
+			name: "values outside the root",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n\tValues: values/../../secrets.json\n}\n",
+			err:  `test.job:3:10: values "values/../../secrets.json" must be relative to the jobs directory`,
+		},
+		{
+			name: "list value",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n\tValues: values/list.json\n}\n",
+			err:  `test.job:3:10: reading values "values/list.json": value of Database must be a single value`,
+		},
+		{
+			name: "unknown property",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n\tParam: x\n}\n",
+			err:  `test.job:3:2: unknown property "Param" for instance, expecting Template, Values or Params`,
+		},
+		{
+			name: "mixed with resources",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n}\n\n" + razorFragment,
+			err:  "test.job:5:1: instances cannot be mixed with other resources in one job file",
+		},
+		{
+			name: "sections with instances",
+			src:  "instance Razor {\n\tTemplate: templates/latest.job\n}\n\nfinally {\n}\n",
+			err:  "test.job:5:1: finally cannot be declared in a file of instances, declare it in the template",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := Parse("test.job", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jobs, err := Expand(job, root)
+			if len(tt.err) > 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != len(tt.jobs) {
+				t.Fatalf("Expand returned %d jobs, want %d", len(jobs), len(tt.jobs))
+			}
+			for i, j := range jobs {
+				if got := summarize(t, j); got != tt.jobs[i] {
+					t.Errorf("job %d = %s, want %s", i, got, tt.jobs[i])
+				}
+			}
+		})
+	}
+}
+
+// summarize returns the name of job followed by the properties of its first
+// resource and the Args of a Log task in its sections.
+func summarize(t *testing.T, job *Job) string {
+	t.Helper()
+	parts := []string{job.Name}
+	for _, p := range job.Resources[0].Properties {
+		parts = append(parts, p.Name+"="+jsonValue(t, p.Value))
+	}
+	for _, s := range job.Sections {
+		for _, r := range s.Resources {
+			if p := r.Property("Args"); r.Title == "Log" && p != nil {
+				parts = append(parts, "Log.Args="+jsonValue(t, p.Value))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// jsonValue returns the text of a scalar and the JSON of other values.
+func jsonValue(t *testing.T, v Value) string {
+	t.Helper()
+	if s, ok := v.(*Scalar); ok {
+		return s.Text
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -14,15 +14,32 @@ type Tokenizer struct {
 	pos       int
 	w         int
 	canUnread bool
+	stack     []TokenType
 	C         chan Token
+
+	// cur is the position of the last rune read, prev the one before it so
+	// that Unread can step back. start marks where the pending token began.
+	cur, prev   Pos
+	last, prevR rune
+	start       Pos
+	marked      bool
+
+	// style and delim describe how the pending scalar value was written
+	style ScalarStyle
+	delim string
+	// section is set inside an on_failure or finally block
+	section bool
 }
 type Token struct {
-	typ TokenType
-	val string
+	typ   TokenType
+	val   string
+	pos   Pos
+	style ScalarStyle
+	delim string
 }
 
 func (t *Token) String() string {
-	return fmt.Sprintf("typ: %d val: %s", int(t.typ), t.val)
+	return fmt.Sprintf("typ: %d val: %s pos: %s", int(t.typ), t.val, t.pos)
 }
 
 type TokenType int
@@ -42,6 +59,9 @@ const (
 	tCloseBrace
 	tOpenBracket
 	tCloseBracket
+	tImport
+	tImportPath
+	tSection
 )
 
 var TokenMap = map[TokenType]string{
@@ -59,6 +79,9 @@ var TokenMap = map[TokenType]string{
 	tCloseBrace:                 "tCloseBrace",
 	tOpenBracket:                "tOpenBracket",
 	tCloseBracket:               "tCloseBracket",
+	tImport:                     "tImport",
+	tImportPath:                 "tImportPath",
+	tSection:                    "tSection",
 }
 
 const (
@@ -68,8 +91,9 @@ const (
 
 func NewTokenizer(scanner io.RuneScanner) *Tokenizer {
 	return &Tokenizer{
-		r: scanner,
-		C: make(chan Token),
+		r:   scanner,
+		C:   make(chan Token),
+		cur: Pos{Line: 1},
 	}
 }
 
@@ -78,6 +102,7 @@ func (t *Tokenizer) Tokenize() {
 	for f = tokenizeBlock; f != nil; {
 		f = f(t)
 	}
+	t.Send(Token{typ: tEOF})
 	close(t.C)
 }
 
@@ -87,6 +112,14 @@ func (t *Tokenizer) Read() (r rune, w int) {
 		t.w = w
 		t.pos += w
 		t.canUnread = true
+		t.prev, t.prevR = t.cur, t.last
+		if t.last == '\n' {
+			t.cur.Line++
+			t.cur.Col = 1
+		} else {
+			t.cur.Col++
+		}
+		t.last = r
 		return
 	}
 	return -1, 0
@@ -100,13 +133,24 @@ func (t *Tokenizer) Unread() {
 	}
 	t.pos -= t.w
 	t.canUnread = false
+	t.cur, t.last = t.prev, t.prevR
 }
 
 func (t *Tokenizer) Store(r rune) {
+	if !t.marked {
+		t.Mark()
+	}
 	// Should we handle an error here?
 	t.b.WriteRune(r)
 }
 
+// Mark records the position of the last rune read as the start of the token
+// that will be sent next.
+func (t *Tokenizer) Mark() {
+	t.start = t.cur
+	t.marked = true
+}
+
 func (t *Tokenizer) StoreUntil(runes string) {
 	for {
 		r, _ := t.Read()
@@ -120,6 +164,199 @@ func (t *Tokenizer) StoreUntil(runes string) {
 	}
 }
 
+// StoreValue reads a single property value starting at the next rune. A value
+// is either a double-quoted string, a heredoc (<<DELIM or <<-DELIM) or raw text
+// running to the end of the line.
+func (t *Tokenizer) StoreValue() error {
+	r, _ := t.Read()
+	t.Mark()
+	switch r {
+	case -1:
+		return nil
+	case '"':
+		t.style = QuotedStyle
+		return t.StoreQuoted()
+	case '<':
+		r, _ = t.Read()
+		if r == '<' {
+			t.style = HeredocStyle
+			return t.StoreHeredoc()
+		}
+		t.Store('<')
+		if r == -1 || strings.ContainsRune("\r\n", r) {
+			return nil
+		}
+		t.Store(r)
+	default:
+		t.Unread()
+	}
+	t.storeRaw()
+	return nil
+}
+
+// storeRaw stores the rest of a raw value, which ends at the line break.
+// Trailing blanks are not part of it.
+func (t *Tokenizer) storeRaw() {
+	t.StoreUntil("\r\n")
+	raw := strings.TrimRight(t.b.String(), " \t")
+	t.b.Truncate(len(raw))
+}
+
+// StoreQuoted stores the contents of a double-quoted string, decoding escape
+// sequences. The opening quote must already have been read. Only whitespace
+// may follow the closing quote on the same line.
+func (t *Tokenizer) StoreQuoted() error {
+	for {
+		r, _ := t.Read()
+		switch r {
+		case -1, '\r', '\n':
+			return fmt.Errorf("Unterminated quoted string")
+		case '"':
+			return t.expectLineEnd("quoted string")
+		case '\\':
+			if err := t.storeEscape(); err != nil {
+				return err
+			}
+		default:
+			t.Store(r)
+		}
+	}
+}
+
+func (t *Tokenizer) storeEscape() error {
+	r, _ := t.Read()
+	switch r {
+	case '"', '\\', '/':
+		t.Store(r)
+	case 'b':
+		t.Store('\b')
+	case 'f':
+		t.Store('\f')
+	case 'n':
+		t.Store('\n')
+	case 'r':
+		t.Store('\r')
+	case 't':
+		t.Store('\t')
+	case 'u':
+		var code rune
+		for i := 0; i < 4; i++ {
+			h, _ := t.Read()
+			switch {
+			case h >= '0' && h <= '9':
+				code = code<<4 | (h - '0')
+			case h >= 'a' && h <= 'f':
+				code = code<<4 | (h - 'a' + 10)
+			case h >= 'A' && h <= 'F':
+				code = code<<4 | (h - 'A' + 10)
+			default:
+				return fmt.Errorf("Invalid unicode escape in quoted string [char: %q]", h)
+			}
+		}
+		t.Store(code)
+	default:
+		return fmt.Errorf("Invalid escape sequence in quoted string [char: %q]", r)
+	}
+	return nil
+}
+
+// StoreHeredoc stores the lines following a <<DELIM marker up to, but not
+// including, the first line consisting solely of DELIM. With <<-DELIM the
+// indentation shared by all non-blank lines is removed so the body can be
+// indented along with the job. The leading << must already have been read.
+func (t *Tokenizer) StoreHeredoc() error {
+	var strip bool
+	r, _ := t.Read()
+	if r == '-' {
+		strip = true
+	} else if r != -1 {
+		t.Unread()
+	}
+	delim, ok := t.readLine()
+	delim = strings.TrimSpace(delim)
+	t.delim = delim
+	if len(delim) == 0 || strings.Trim(delim, nameAllowed) != "" {
+		return fmt.Errorf("Invalid heredoc delimiter %q", delim)
+	}
+	var lines []string
+	for ok {
+		var line string
+		line, ok = t.readLine()
+		if strings.TrimSpace(line) == delim {
+			if strip {
+				lines = dedent(lines)
+			}
+			for i, l := range lines {
+				if i > 0 {
+					t.Store('\n')
+				}
+				t.b.WriteString(l)
+			}
+			return nil
+		}
+		lines = append(lines, line)
+	}
+	return fmt.Errorf("Heredoc not terminated by %s before end of file", delim)
+}
+
+// dedent removes the leading whitespace common to all non-blank lines and
+// empties lines holding only whitespace.
+func dedent(lines []string) []string {
+	var prefix string
+	first := true
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			out[i] = l[len(prefix):]
+		}
+	}
+	return out
+}
+
+// readLine reads up to and including the next line feed, returning the line
+// without its line ending. ok is false when the end of input was reached.
+func (t *Tokenizer) readLine() (line string, ok bool) {
+	var buf bytes.Buffer
+	for {
+		r, _ := t.Read()
+		switch r {
+		case -1:
+			return strings.TrimSuffix(buf.String(), "\r"), false
+		case '\n':
+			return strings.TrimSuffix(buf.String(), "\r"), true
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func (t *Tokenizer) expectLineEnd(after string) error {
+	for {
+		r, _ := t.Read()
+		switch {
+		case r == -1 || r == '\n':
+			return nil
+		case strings.ContainsRune(whitespace, r):
+			continue
+		default:
+			return fmt.Errorf("Unexpected character after %s [char: %q]", after, r)
+		}
+	}
+}
+
 func (t *Tokenizer) SkipWhile(runes string) {
 	for {
 		r, _ := t.Read()
@@ -134,8 +371,17 @@ func (t *Tokenizer) SkipWhile(runes string) {
 	}
 }
 
+// Send emits token positioned at the start of its text, or at the last rune
+// read for errors and tokens that stored no text.
 func (t *Tokenizer) Send(token Token) {
+	token.pos = t.cur
+	if t.marked && token.typ != tError {
+		token.pos = t.start
+	}
+	token.style, token.delim = t.style, t.delim
 	t.b.Reset()
+	t.marked = false
+	t.style, t.delim = RawStyle, ""
 	t.C <- token
 }
 
@@ -148,38 +394,59 @@ func tokenizeBlock(t *Tokenizer) tFunc {
 		switch {
 		case r == '/':
 			t.Unread()
-			return tokenizeComment
+			return tokenizeComment(tokenizeBlock)
 		case strings.ContainsRune(nameAllowed, r):
 			t.Unread()
 			return tokenizeProvider
 		case strings.ContainsRune(whitespace, r):
 			continue
+		case r == '}' && t.section:
+			t.Send(Token{
+				typ: tCloseBrace,
+				val: "}",
+			})
+			t.section = false
+			continue
 		default:
 			t.Send(Token{
 				typ: tError,
-				val: fmt.Sprintf("Invalid character read when expecting provider or comment [pos: %d, char: %q]", t.pos, r),
+				val: fmt.Sprintf("Invalid character read when expecting provider or comment [char: %q]", r),
 			})
 			return nil
 		}
 	}
 }
 
-func tokenizeComment(t *Tokenizer) tFunc {
-	for {
-		r, _ := t.Read()
-		if r == -1 {
-			return nil
-		}
+// tokenizeComment reads a // comment up to the end of the line and continues
+// with next, so comments may appear between resources and between properties.
+func tokenizeComment(next tFunc) tFunc {
+	return func(t *Tokenizer) tFunc {
+		for {
+			r, _ := t.Read()
+			if r == -1 {
+				t.Send(Token{
+					typ: tComment,
+					val: strings.TrimRight(t.b.String(), whitespace),
+				})
+				return nil
+			}
 
-		switch {
-		case strings.ContainsRune("\r\n", r):
-			t.Send(Token{
-				typ: tComment,
-				val: t.b.String(),
-			})
-			return tokenizeBlock
-		default:
-			t.Store(r)
+			switch {
+			case t.b.Len() < 2 && r != '/':
+				t.Send(Token{
+					typ: tError,
+					val: fmt.Sprintf("Invalid character read when expecting comment [char: %q]", r),
+				})
+				return nil
+			case strings.ContainsRune("\r\n", r):
+				t.Send(Token{
+					typ: tComment,
+					val: strings.TrimRight(t.b.String(), whitespace),
+				})
+				return next
+			default:
+				t.Store(r)
+			}
 		}
 	}
 }
@@ -194,22 +461,94 @@ func tokenizeProvider(t *Tokenizer) tFunc {
 		switch {
 		case strings.ContainsRune(nameAllowed, r):
 			t.Store(r)
+		case (strings.ContainsRune(whitespace, r) || r == '{') && isSection(t.b.String()):
+			t.Unread()
+			t.Send(Token{
+				typ: tSection,
+				val: t.b.String(),
+			})
+			return tokenizeSection
 		case strings.ContainsRune(whitespace, r):
+			if t.b.String() == "import" {
+				t.Send(Token{
+					typ: tImport,
+					val: "import",
+				})
+				return tokenizeImport
+			}
 			t.Send(Token{
 				typ: tProvider,
 				val: t.b.String(),
 			})
 			return tokenizeResourceTitle
+		case r == '{' && t.b.Len() > 0:
+			// title-less block such as job {
+			t.Unread()
+			t.Send(Token{
+				typ: tProvider,
+				val: t.b.String(),
+			})
+			return tokenizeResourceBlock
 		default:
 			t.Send(Token{
 				typ: tError,
-				val: fmt.Sprintf("Invalid character read when expecting provider [pos: %d, char: %q]", t.pos, r),
+				val: fmt.Sprintf("Invalid character read when expecting provider [char: %q]", r),
 			})
 			return nil
 		}
 	}
 }
 
+func isSection(word string) bool {
+	return word == OnFailureSection || word == FinallySection
+}
+
+// tokenizeSection reads the opening brace of an on_failure or finally block,
+// the resources inside are tokenized like those at the top level.
+func tokenizeSection(t *Tokenizer) tFunc {
+	t.SkipWhile(whitespace)
+	r, _ := t.Read()
+	if r != '{' {
+		t.Send(Token{
+			typ: tError,
+			val: fmt.Sprintf("Invalid character read when expecting { [char: %q]", r),
+		})
+		return nil
+	}
+	t.Send(Token{
+		typ: tOpenBrace,
+		val: "{",
+	})
+	t.section = true
+	return tokenizeBlock
+}
+
+// tokenizeImport reads the quoted path following the import keyword.
+func tokenizeImport(t *Tokenizer) tFunc {
+	t.SkipWhile(" \t")
+	r, _ := t.Read()
+	if r != '"' {
+		t.Send(Token{
+			typ: tError,
+			val: fmt.Sprintf("Invalid character read when expecting quoted import path [char: %q]", r),
+		})
+		return nil
+	}
+	t.Mark()
+	if err := t.StoreQuoted(); err != nil {
+		t.Send(Token{
+			typ: tError,
+			val: err.Error(),
+		})
+		return nil
+	}
+	t.Send(Token{
+		typ: tImportPath,
+		val: t.b.String(),
+	})
+	return tokenizeBlock
+}
+
 func tokenizeResourceTitle(t *Tokenizer) tFunc {
 	for {
 		r, _ := t.Read()
@@ -227,10 +566,13 @@ func tokenizeResourceTitle(t *Tokenizer) tFunc {
 				})
 				return tokenizeResourceBlock
 			}
+		case r == '{' && t.b.Len() == 0:
+			t.Unread()
+			return tokenizeResourceBlock
 		default:
 			t.Send(Token{
 				typ: tError,
-				val: fmt.Sprintf("Invalid character read when expecting resource title [pos: %d, char: %q]", t.pos, r),
+				val: fmt.Sprintf("Invalid character read when expecting resource title [char: %q]", r),
 			})
 			return nil
 		}
@@ -256,7 +598,7 @@ func tokenizeResourceBlock(t *Tokenizer) tFunc {
 		default:
 			t.Send(Token{
 				typ: tError,
-				val: fmt.Sprintf("Invalid character read when expecting { or whitespace [pos: %d, char: %q]", t.pos, r),
+				val: fmt.Sprintf("Invalid character read when expecting { or whitespace [char: %q]", r),
 			})
 			return nil
 		}
@@ -271,8 +613,15 @@ func tokenizeResourcePropertyName(t *Tokenizer) tFunc {
 		}
 
 		switch {
-		case strings.ContainsRune(whitespace, r):
+		case strings.ContainsRune(whitespace, r) && t.b.Len() == 0:
 			continue
+		case strings.ContainsRune(whitespace, r):
+			if !t.expectColon("property name") {
+				return nil
+			}
+		case r == '/' && t.b.Len() == 0:
+			t.Unread()
+			return tokenizeComment(tokenizeResourcePropertyName)
 		case strings.ContainsRune(nameAllowed, r):
 			t.Store(r)
 		case r == ':':
@@ -290,7 +639,7 @@ func tokenizeResourcePropertyName(t *Tokenizer) tFunc {
 		default:
 			t.Send(Token{
 				typ: tError,
-				val: fmt.Sprintf("Invalid character read when expecting property name, colon, or whitespace [pos: %d, char: %q]", t.pos, r),
+				val: fmt.Sprintf("Invalid character read when expecting property name, colon, or whitespace [char: %q]", r),
 			})
 			return nil
 		}
@@ -298,38 +647,126 @@ func tokenizeResourcePropertyName(t *Tokenizer) tFunc {
 }
 
 func tokenizeResourcePropertyValue(t *Tokenizer) tFunc {
-	for {
-		r, _ := t.Read()
-		if r == -1 {
-			return nil
-		}
-		switch {
-		case strings.ContainsRune(" \t", r):
-			continue
-		case r == '[':
-			t.Send(Token{
-				typ: tOpenBracket,
-				val: "[",
-			})
-			t.SkipWhile(whitespace)
-			return tokenizeResourcePropertyArray
-		case r == '{':
-			t.Send(Token{
-				typ: tOpenBrace,
-				val: "{",
-			})
-			return tokenizeResourcePropertyMap
-		default:
-			t.Unread()
-			t.StoreUntil("\r\n")
-			t.SkipWhile("\r\n")
-			t.Send(Token{
-				typ: tResourcePropertyValue,
-				val: t.b.String(),
-			})
-			return tokenizeResourcePropertyName
-		}
+	t.SkipWhile(" \t")
+	return t.tokenizeValue(tResourcePropertyValue)
+}
+
+// tokenizeValue emits the value at the current position. Scalars are sent as
+// typ, arrays and maps open a new nesting level which is closed again by
+// tokenizeResourcePropertyArray and tokenizeResourcePropertyMap.
+func (t *Tokenizer) tokenizeValue(typ TokenType) tFunc {
+	r, _ := t.Read()
+	switch r {
+	case -1:
+		return nil
+	case '[':
+		t.stack = append(t.stack, tOpenBracket)
+		t.Send(Token{
+			typ: tOpenBracket,
+			val: "[",
+		})
+		return t.afterOpen(r, ']', tokenizeResourcePropertyArray)
+	case '{':
+		t.stack = append(t.stack, tOpenBrace)
+		t.Send(Token{
+			typ: tOpenBrace,
+			val: "{",
+		})
+		return t.afterOpen(r, '}', tokenizeResourcePropertyMap)
+	}
+	t.Unread()
+	if err := t.StoreValue(); err != nil {
+		t.Send(Token{
+			typ: tError,
+			val: err.Error(),
+		})
+		return nil
+	}
+	return t.sendValue(typ)
+}
+
+// afterOpen checks the rest of the line after the opening rune of an array or
+// map and continues with next. Entries start on the next line, only the
+// closing rune of an empty value or a comment may follow on the same line.
+func (t *Tokenizer) afterOpen(open, close rune, next tFunc) tFunc {
+	t.SkipWhile(" \t")
+	r, _ := t.Read()
+	switch {
+	case r == -1:
+		return next
+	case r == close || r == '\r' || r == '\n':
+		t.Unread()
+		return next
+	case r == '/' && t.startsComment():
+		return tokenizeComment(next)
 	}
+	t.Send(Token{
+		typ: tError,
+		val: fmt.Sprintf("Invalid character read after %c, entries start on the next line [char: %q]", open, r),
+	})
+	return nil
+}
+
+// sendValue emits the stored scalar as typ.
+func (t *Tokenizer) sendValue(typ TokenType) tFunc {
+	t.SkipWhile("\r\n")
+	t.Send(Token{
+		typ: typ,
+		val: t.b.String(),
+	})
+	return t.afterValue()
+}
+
+// startsComment reports whether the '/' just read opens a // comment. The
+// slash is stored either way: a comment continues with tokenizeComment, any
+// other text is a value starting with the slash.
+func (t *Tokenizer) startsComment() bool {
+	t.Store('/')
+	r, _ := t.Read()
+	if r == '/' {
+		t.Store(r)
+		return true
+	}
+	if r != -1 {
+		t.Unread()
+	}
+	return false
+}
+
+// expectColon reads past the blanks following the name of a property or map
+// key, which must be followed by its colon. Names holding blanks are errors.
+func (t *Tokenizer) expectColon(what string) bool {
+	t.SkipWhile(" \t")
+	r, _ := t.Read()
+	switch r {
+	case -1:
+		return false
+	case ':':
+		t.Unread()
+		return true
+	}
+	t.Send(Token{
+		typ: tError,
+		val: fmt.Sprintf("Invalid character read when expecting colon after %s %q, names cannot contain whitespace [char: %q]", what, t.b.String(), r),
+	})
+	return false
+}
+
+// afterValue returns the state to continue in once a value has been read,
+// depending on whether it was nested inside an array, a map or neither.
+func (t *Tokenizer) afterValue() tFunc {
+	if len(t.stack) == 0 {
+		return tokenizeResourcePropertyName
+	}
+	if t.stack[len(t.stack)-1] == tOpenBracket {
+		return tokenizeResourcePropertyArray
+	}
+	return tokenizeResourcePropertyMap
+}
+
+func (t *Tokenizer) pop() tFunc {
+	t.stack = t.stack[:len(t.stack)-1]
+	return t.afterValue()
 }
 
 func tokenizeResourcePropertyArray(t *Tokenizer) tFunc {
@@ -340,22 +777,23 @@ func tokenizeResourcePropertyArray(t *Tokenizer) tFunc {
 		}
 
 		switch {
-		case strings.ContainsRune(" \t", r):
+		case strings.ContainsRune(whitespace, r):
 			continue
 		case r == ']':
 			t.Send(Token{
 				typ: tCloseBracket,
 				val: "]",
 			})
-			return tokenizeResourcePropertyName
+			return t.pop()
+		case r == '/':
+			if t.startsComment() {
+				return tokenizeComment(tokenizeResourcePropertyArray)
+			}
+			t.storeRaw()
+			return t.sendValue(tResourcePropertyArrayValue)
 		default:
 			t.Unread()
-			t.StoreUntil("\r\n")
-			t.SkipWhile("\r\n")
-			t.Send(Token{
-				typ: tResourcePropertyArrayValue,
-				val: t.b.String(),
-			})
+			return t.tokenizeValue(tResourcePropertyArrayValue)
 		}
 	}
 }
@@ -372,21 +810,24 @@ func tokenizeResourcePropertyMap(t *Tokenizer) tFunc {
 				typ: tCloseBrace,
 				val: "}",
 			})
-			return tokenizeResourcePropertyName
+			return t.pop()
 		case r == ':':
 			t.Send(Token{
 				typ: tResourcePropertyMapName,
 				val: t.b.String(),
 			})
 			t.SkipWhile(" \t")
-			t.StoreUntil("\r\n")
-			t.SkipWhile("\r\n")
-			t.Send(Token{
-				typ: tResourcePropertyMapValue,
-				val: t.b.String(),
-			})
-		case strings.ContainsRune(whitespace, r):
+			return t.tokenizeValue(tResourcePropertyMapValue)
+		case strings.ContainsRune(whitespace, r) && t.b.Len() == 0:
 			continue
+		case strings.ContainsRune(whitespace, r):
+			if !t.expectColon("map key") {
+				return nil
+			}
+		case r == '/' && t.b.Len() == 0:
+			if t.startsComment() {
+				return tokenizeComment(tokenizeResourcePropertyMap)
+			}
 		default:
 			t.Store(r)
 		}
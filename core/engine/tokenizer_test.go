@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// tokenize returns the tokens of src, ending with tEOF. The tokenizer stops
+// at the first error.
+func tokenize(src string) (tokens []Token) {
+	t := NewTokenizer(bufio.NewReader(strings.NewReader(src)))
+	go t.Tokenize()
+	for token := range t.C {
+		tokens = append(tokens, token)
+	}
+	return
+}
+
+// value returns the value of the first property of src, or the error the
+// tokenizer reported.
+func value(src string) (val string, style ScalarStyle, err string) {
+	for _, token := range tokenize(src) {
+		switch token.typ {
+		case tError:
+			return "", 0, token.val
+		case tResourcePropertyValue:
+			return token.val, token.style, ""
+		}
+	}
+	return
+}
+
+func TestTokenizeValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		style ScalarStyle
+	}{
+		{"raw", "echo hello  ", "echo hello", RawStyle},
+		{"raw with quotes inside", `say "hi"`, `say "hi"`, RawStyle},
+		{"quoted", `"  padded  "`, "  padded  ", QuotedStyle},
+		{"escapes", `"a\"b\\c\/d\te\nf"`, "a\"b\\c/d\te\nf", QuotedStyle},
+		{"unicode escape", `"\u00e9t\u00C9"`, "étÉ", QuotedStyle},
+		{"quoted braces", `"{not a map}"`, "{not a map}", QuotedStyle},
+		{"heredoc", "<<EOF\nline 1\n  line 2\nEOF", "line 1\n  line 2", HeredocStyle},
+		{"indented heredoc", "<<-EOF\n\t\techo a\n\t\t\techo b\n\n\t\techo c\n\tEOF", "echo a\n\techo b\n\necho c", HeredocStyle},
+		{"heredoc keeps references", "<<SH\necho ${GREETING:-hello} $(Task.Stdout)\nSH", "echo ${GREETING:-hello} $(Task.Stdout)", HeredocStyle},
+		{"empty heredoc", "<<EOF\nEOF", "", HeredocStyle},
+		{"less than", "< 5", "< 5", RawStyle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, style, err := value("localexec Run {\n\tScript: " + tt.value + "\n}\n")
+			if len(err) > 0 {
+				t.Fatal(err)
+			}
+			if got != tt.want || style != tt.style {
+				t.Errorf("value = %q (style %d), want %q (style %d)", got, style, tt.want, tt.style)
+			}
+		})
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"unterminated quoted", "a b {\n\tX: \"open\n}\n", "Unterminated quoted string"},
+		{"invalid escape", "a b {\n\tX: \"\\q\"\n}\n", `Invalid escape sequence in quoted string [char: 'q']`},
+		{"invalid unicode", "a b {\n\tX: \"\\u12g4\"\n}\n", "Invalid unicode escape"},
+		{"text after quote", "a b {\n\tX: \"x\" y\n}\n", `Unexpected character after quoted string [char: 'y']`},
+		{"heredoc delimiter", "a b {\n\tX: <<E F\nE F\n}\n", `Invalid heredoc delimiter "E F"`},
+		{"unterminated heredoc", "a b {\n\tX: <<EOF\nbody\n}\n", "Heredoc not terminated by EOF before end of file"},
+		{"provider", "a$ b {\n}\n", "expecting provider"},
+		{"title", "a b$ {\n}\n", "expecting resource title"},
+		{"property name", "a b {\n\tX$: 1\n}\n", "expecting property name"},
+		{"import path", "import jobs/x\n", "expecting quoted import path"},
+		{"comment", "/x\n", "expecting comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, token := range tokenize(tt.src) {
+				if token.typ == tError {
+					if !strings.Contains(token.val, tt.err) {
+						t.Errorf("error = %q, want %q", token.val, tt.err)
+					}
+					return
+				}
+			}
+			t.Errorf("no error, want %q", tt.err)
+		})
+	}
+}
+
+func TestTokenizePositions(t *testing.T) {
+	src := "// header\nlocalexec Run {\n\tFile: echo\n\tArgs:[\n\t\t\"a\"\n\t]\n}\n"
+	type tok struct {
+		typ       TokenType
+		val       string
+		line, col int
+	}
+	want := []tok{
+		{tComment, "// header", 1, 1},
+		{tProvider, "localexec", 2, 1},
+		{tResourceTitle, "Run", 2, 11},
+		{tOpenBrace, "{", 2, 15},
+		{tResourcePropertyName, "File", 3, 2},
+		{tResourcePropertyValue, "echo", 3, 8},
+		{tResourcePropertyName, "Args", 4, 2},
+		{tOpenBracket, "[", 4, 7},
+		{tResourcePropertyArrayValue, "a", 5, 3},
+		{tCloseBracket, "]", 6, 2},
+		{tCloseBrace, "}", 7, 1},
+		{tEOF, "", 7, 2},
+	}
+	tokens := tokenize(src)
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %v", len(tokens), len(want), tokens)
+	}
+	for i, w := range want {
+		got := tokens[i]
+		if got.typ != w.typ || got.val != w.val || got.pos.Line != w.line || got.pos.Col != w.col {
+			t.Errorf("token %d = %s %s, want %s %q at %d:%d", i, TokenMap[got.typ], got.String(), TokenMap[w.typ], w.val, w.line, w.col)
+		}
+	}
+}
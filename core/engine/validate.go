@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// Validate checks a job against the schemas of the providers it uses. It
+// reports unknown providers, unknown, mistyped or missing properties and
+// $(Task.Output) references that no earlier task in the job produces as well
+// as ${param:name} references left outside a template.
+func Validate(job *Job, schemas map[string]core.Schema) (errs []error) {
+	v := &validator{job: job}
+	var block *Resource
+	for i, r := range job.Resources {
+		if r.Provider == JobProvider {
+			if block != nil {
+				v.errorf(r, r.Pos, "job block already declared at %s:%s", block.File, block.Pos)
+			}
+			block = r
+			v.checkProperties(r, JobOptionsSchema)
+			continue
+		}
+		v.checkResource(r, job.Resources[:i], schemas)
+	}
+	var options core.JobOptions
+	if block != nil {
+		// errors in the options have been reported by checkProperties
+		splitOptions(block, JobOptionsSchema, &options)
+	}
+	if _, err := jobDependencies(job, options.Parallel); err != nil {
+		v.errs = append(v.errs, err)
+	}
+	// the tasks of a section may refer to every task of the job, any of them
+	// may have run, and to the error that failed the run
+	v.section = true
+	for _, s := range job.Sections {
+		for i, r := range s.Resources {
+			v.checkResource(r, append(append([]*Resource{}, job.Resources...), s.Resources[:i]...), schemas)
+		}
+	}
+	return v.errs
+}
+
+type validator struct {
+	job  *Job
+	errs []error
+	// section is set while checking the resources of on_failure and finally
+	section bool
+}
+
+func (v *validator) checkResource(r *Resource, earlier []*Resource, schemas map[string]core.Schema) {
+	n := len(v.errs)
+	schema, ok := schemas[r.Provider]
+	if !ok {
+		v.errorf(r, r.Pos, "unknown provider %q%s", r.Provider, suggest(r.Provider, schemaNames(schemas)))
+	} else {
+		v.checkProperties(r, schema)
+	}
+	// decode the options the way Compile does, unless their types were
+	// already found wrong
+	if len(v.errs) == n {
+		var options core.TaskOptions
+		if _, err := splitOptions(r, TaskOptionsSchema, &options); err != nil {
+			v.errs = append(v.errs, err)
+		}
+	}
+	for _, p := range r.Properties {
+		v.checkReferences(r, p.Value, earlier, schemas)
+	}
+}
+
+// errorf records an error at pos in the file resource r was parsed from.
+func (v *validator) errorf(r *Resource, pos Pos, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ParseError{
+		File: r.File,
+		Pos:  pos,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) checkProperties(r *Resource, schema core.Schema) {
+	var names []string
+	for _, ps := range schema.Properties {
+		names = append(names, ps.Name)
+	}
+	if r.Provider != JobProvider {
+		for _, ps := range TaskOptionsSchema.Properties {
+			names = append(names, ps.Name)
+		}
+	}
+	for _, p := range r.Properties {
+		ps, ok := schema.Property(p.Name)
+		if !ok && r.Provider != JobProvider {
+			ps, ok = TaskOptionsSchema.Property(p.Name)
+		}
+		if !ok && r.Provider == JobProvider {
+			v.errorf(r, p.Pos, "unknown job option %q%s", p.Name, suggest(p.Name, names))
+			continue
+		}
+		if !ok {
+			v.errorf(r, p.Pos, "unknown property %q for provider %s%s", p.Name, r.Provider, suggest(p.Name, names))
+			continue
+		}
+		v.checkType(r, p, ps)
+	}
+	for _, ps := range schema.Properties {
+		if ps.Required && r.Property(ps.Name) == nil {
+			v.errorf(r, r.Pos, "%s %s is missing required property %s", r.Provider, r.Title, ps.Name)
+		}
+	}
+}
+
+func (v *validator) checkType(r *Resource, p *Property, ps core.PropertySchema) {
+	var got core.PropertyType
+	switch value := p.Value.(type) {
+	case *Scalar:
+		got = value.Type()
+	case *Array:
+		got = core.TypeArray
+	case *Map:
+		got = core.TypeMap
+	}
+	if !assignable(p.Value, got, ps.Type) {
+		v.errorf(r, p.Value.Position(), "property %s must be of type %s, not %s", p.Name, ps.Type, got)
+		return
+	}
+	s, ok := p.Value.(*Scalar)
+	if !ok || len(ps.Enum) == 0 || len(core.FindExpressions(s.Text)) > 0 {
+		return
+	}
+	for _, e := range ps.Enum {
+		if s.Text == e {
+			return
+		}
+	}
+	v.errorf(r, s.Pos, "invalid value %q for %s, expecting one of %s", s.Text, p.Name, strings.Join(ps.Enum, ", "))
+}
+
+// assignable reports whether a value of type got can be used for a property
+// of type want. Strings take any scalar, and scalars holding a reference are
+// only known once interpolated so they are accepted for any scalar type.
+func assignable(value Value, got, want core.PropertyType) bool {
+	if want == core.TypeAny || got == want {
+		return true
+	}
+	s, ok := value.(*Scalar)
+	if !ok {
+		return false
+	}
+	if want == core.TypeArray || want == core.TypeMap {
+		return got == core.TypeAny
+	}
+	if want == core.TypeString || got == core.TypeAny || len(core.FindExpressions(s.Text)) > 0 || len(core.FindReferences(s.Text)) > 0 {
+		return true
+	}
+	switch want {
+	case core.TypeNumber:
+		return got == core.TypeInt
+	case core.TypeDuration:
+		return got == core.TypeInt || got == core.TypeNumber
+	}
+	return false
+}
+
+// checkReferences walks value looking for $(Task.Output) references that are
+// not published by one of the earlier resources.
+func (v *validator) checkReferences(r *Resource, value Value, earlier []*Resource, schemas map[string]core.Schema) {
+	switch value := value.(type) {
+	case *Array:
+		for _, e := range value.Elems {
+			v.checkReferences(r, e, earlier, schemas)
+		}
+	case *Map:
+		for _, e := range value.Entries {
+			v.checkReferences(r, e.Value, earlier, schemas)
+		}
+	case *Scalar:
+		for _, content := range core.FindExpressions(value.Text) {
+			e, err := core.ParseExpression(content)
+			if err == nil {
+				_, err = core.ParsePath("." + e.Ref)
+			}
+			if err != nil {
+				v.errorf(r, value.Pos, "invalid reference $(%s): %v", content, err)
+				continue
+			}
+			if v.section && (e.Ref == core.ErrorMessageOutput || e.Ref == core.ErrorTaskOutput) {
+				continue
+			}
+			if r.Property("foreach") != nil && isIterationReference(e.Ref) {
+				continue
+			}
+			if !produced(e.Ref, earlier, schemas) {
+				v.errorf(r, value.Pos, "reference $(%s) is not produced by any earlier task", content)
+			}
+		}
+		for _, m := range core.FindReferences(value.Text) {
+			if m[1] == "param" {
+				v.errorf(r, value.Pos, "reference %s outside a template, parameters are only declared by templates", m[0])
+			}
+		}
+	}
+}
+
+// isIterationReference reports whether ref is the item or index of a
+// foreach task.
+func isIterationReference(ref string) bool {
+	return ref == core.ForEachIndex || ref == core.ForEachItem ||
+		strings.HasPrefix(ref, core.ForEachItem+".") || strings.HasPrefix(ref, core.ForEachItem+"[")
+}
+
+// produced reports whether an earlier task publishes the output ref names,
+// or the output a path in ref starts from.
+func produced(ref string, earlier []*Resource, schemas map[string]core.Schema) bool {
+	for _, key := range core.ReferenceKeys(ref) {
+		if producedKey(key, earlier, schemas) {
+			return true
+		}
+	}
+	return false
+}
+
+func producedKey(ref string, earlier []*Resource, schemas map[string]core.Schema) bool {
+	i := strings.Index(ref, ".")
+	if i < 0 {
+		return false
+	}
+	title, output := ref[:i], ref[i+1:]
+	for _, r := range earlier {
+		if r.Title != title {
+			continue
+		}
+		// unknown providers have already been reported, don't pile on
+		schema, ok := schemas[r.Provider]
+		if !ok || schema.HasOutput(output) || TaskOptionsSchema.HasOutput(output) {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaNames(schemas map[string]core.Schema) (names []string) {
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// suggest returns a "did you mean" hint for the candidate closest to name.
+func suggest(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := distance(strings.ToLower(name), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+var testSchemas = map[string]core.Schema{
+	"localexec": {
+		Properties: []core.PropertySchema{
+			{Name: "File", Type: core.TypeString, Required: true},
+			{Name: "Args", Type: core.TypeArray, Required: true},
+		},
+		Outputs: []string{"Stdout", "Stderr"},
+	},
+	"mongo": {
+		Properties: []core.PropertySchema{
+			{Name: "Database", Type: core.TypeString, Required: true},
+			{Name: "Collection", Type: core.TypeString, Required: true},
+			{Name: "Limit", Type: core.TypeInt},
+		},
+		Outputs: []string{"Result"},
+	},
+	"ticker": {
+		Properties: []core.PropertySchema{
+			{Name: "Every", Type: core.TypeDuration},
+			{Name: "Period", Type: core.TypeString, Enum: []string{"Second", "Minute"}},
+		},
+	},
+}
+
+// validate parses src and returns the messages of the errors Validate
+// reports for it.
+func validate(t *testing.T, src string) (msgs []string) {
+	t.Helper()
+	job, err := Parse("test.job", []byte(src))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	for _, err := range Validate(job, testSchemas) {
+		msgs = append(msgs, err.Error())
+	}
+	return
+}
+
+func TestValidateReferences(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      string
+	}{
+		{"shell default", `localexec Script {
+	File: sh
+	Args:[
+		-c
+		<<-EOF
+			echo ${GREETING:-hello} ${USER}
+		EOF
+	]
+}
+`, ""},
+		{"env and secret", `localexec Backup {
+	File: backup
+	Args:[
+		${env:BACKUP_USER}
+		${secret:backup_token}
+	]
+}
+`, ""},
+		{"param outside a template", `mongo Latest {
+	Database: ${param:Database}
+	Collection: nodes
+}
+`, "reference ${param:Database} outside a template"},
+		{"typed reference", `mongo Latest {
+	Database: razor
+	Collection: nodes
+	Limit: ${env:LIMIT}
+}
+`, ""},
+		{"typed shell variable", `mongo Latest {
+	Database: razor
+	Collection: nodes
+	Limit: ${LIMIT:-1}
+}
+`, "property Limit must be of type int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := validate(t, tt.src)
+			if len(tt.want) == 0 {
+				if len(msgs) > 0 {
+					t.Errorf("unexpected errors %q", msgs)
+				}
+				return
+			}
+			if len(msgs) != 1 || !strings.Contains(msgs[0], tt.want) {
+				t.Errorf("errors %q, want one containing %q", msgs, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name, src string
+		want      []string
+	}{
+		{"valid", `mongo Latest {
+	Database: razor
+	Collection: nodes
+	Limit: 5
+}
+localexec Report {
+	File: report
+	Args:[
+		$(Latest.Result)
+	]
+}
+`, nil},
+		{"unknown provider", `mongoo Latest {
+	Database: razor
+}
+`, []string{`test.job:1:1: unknown provider "mongoo", did you mean mongo?`}},
+		{"unknown property", `mongo Latest {
+	Database: razor
+	Colection: nodes
+}
+`, []string{
+			`test.job:3:2: unknown property "Colection" for provider mongo, did you mean Collection?`,
+			`test.job:1:1: mongo Latest is missing required property Collection`,
+		}},
+		{"mistyped", `mongo Latest {
+	Database: razor
+	Collection: nodes
+	Limit: five
+}
+`, []string{`test.job:4:9: property Limit must be of type int, not string`}},
+		{"array for a string", `mongo Latest {
+	Database:[
+		razor
+	]
+	Collection: nodes
+}
+`, []string{`test.job:2:11: property Database must be of type string, not array`}},
+		{"enum", `ticker Tick {
+	Period: Hour
+}
+`, []string{`test.job:2:10: invalid value "Hour" for Period, expecting one of Second, Minute`}},
+		{"later reference", `localexec Report {
+	File: report
+	Args:[
+		$(Latest.Result)
+	]
+}
+mongo Latest {
+	Database: razor
+	Collection: nodes
+}
+`, []string{`test.job:4:3: reference $(Latest.Result) is not produced by any earlier task`}},
+		{"unknown output", `mongo Latest {
+	Database: razor
+	Collection: nodes
+}
+localexec Report {
+	File: report
+	Args:[
+		$(Latest.Stdout)
+	]
+}
+`, []string{`test.job:8:3: reference $(Latest.Stdout) is not produced by any earlier task`}},
+		{"job option", `job {
+	paralel: true
+}
+`, []string{`test.job:2:2: unknown job option "paralel", did you mean parallel?`}},
+		{"error outside a section", `localexec Report {
+	File: report
+	Args:[
+		$(error.Message)
+	]
+}
+`, []string{`test.job:4:3: reference $(error.Message) is not produced by any earlier task`}},
+		{"error in a section", `localexec Report {
+	File: report
+	Args:[]
+}
+on_failure {
+	localexec Alert {
+		File: alert
+		Args:[
+			$(error.Task) $(Report.Stdout)
+		]
+	}
+}
+`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := validate(t, tt.src)
+			if strings.Join(msgs, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(msgs, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
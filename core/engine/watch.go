@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// JobChanges lists the jobs that differ between two polls of a jobs
+// directory, jobs are compared by name and Hash.
+type JobChanges struct {
+	Added   []*core.RPCJob
+	Changed []*core.RPCJob
+	Removed []string
+}
+
+func (c JobChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// Watcher polls a jobs directory for added, changed and removed job files.
+// Every poll reloads the whole directory so changes to imported files and
+// templates reach the jobs using them. A file that fails to load keeps the
+// jobs it produced last, a half edited file never withdraws a running job.
+//
+// Changes are compared with the jobs the runners are known to run, those
+// recorded by Applied, so a change that could not be applied is reported
+// again by the next poll.
+type Watcher struct {
+	Root     string
+	Interval time.Duration
+
+	// jobs holds the applied version of every job
+	jobs map[string]*core.RPCJob
+	// files maps each job file to the names of the jobs it produced
+	files map[string][]string
+	// errs holds the last error of each failing file, so it is logged once
+	errs map[string]string
+	quit chan bool
+}
+
+func NewWatcher(root string, interval time.Duration) *Watcher {
+	return &Watcher{
+		Root:     root,
+		Interval: interval,
+		jobs:     make(map[string]*core.RPCJob),
+		files:    make(map[string][]string),
+		errs:     make(map[string]string),
+		quit:     make(chan bool),
+	}
+}
+
+// Load reads the jobs directory for the first time and returns every job,
+// the jobs that were dispatched are handed to Applied. Files that fail to
+// load are returned as an ErrorList.
+func (w *Watcher) Load() (jobs []*core.RPCJob, err error) {
+	var changes JobChanges
+	changes, err = w.Poll()
+	return changes.Added, err
+}
+
+// Poll reloads the jobs directory and returns the changes to the applied
+// jobs. Errors of files that already failed the last time are not repeated.
+func (w *Watcher) Poll() (changes JobChanges, err error) {
+	var files []string
+	if files, err = JobFiles(w.Root); err != nil {
+		return
+	}
+	var errs ErrorList
+	jobs := make(map[string]*core.RPCJob)
+	names := make(map[string][]string)
+	for _, f := range files {
+		loaded, err := loadJobs(f, w.Root)
+		if err != nil {
+			if msg := err.Error(); w.errs[f] != msg {
+				w.errs[f] = msg
+				errs = append(errs, err)
+			}
+			for _, name := range w.files[f] {
+				if job, ok := w.jobs[name]; ok {
+					jobs[name] = job
+				}
+			}
+			names[f] = w.files[f]
+			continue
+		}
+		delete(w.errs, f)
+		for _, job := range loaded {
+			jobs[job.Name] = job
+			names[f] = append(names[f], job.Name)
+		}
+	}
+	for f := range w.errs {
+		if _, ok := names[f]; !ok {
+			delete(w.errs, f)
+		}
+	}
+
+	for name, job := range jobs {
+		old, ok := w.jobs[name]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, job)
+		case old.Hash != job.Hash:
+			changes.Changed = append(changes.Changed, job)
+		}
+	}
+	for name := range w.jobs {
+		if _, ok := jobs[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	sortJobs(changes.Added)
+	sortJobs(changes.Changed)
+	sort.Strings(changes.Removed)
+
+	w.files = names
+	return changes, errs.Err()
+}
+
+// Applied records changes as applied to the runners, later polls compare the
+// jobs directory with them.
+func (w *Watcher) Applied(changes JobChanges) {
+	for _, job := range changes.Added {
+		w.jobs[job.Name] = job
+	}
+	for _, job := range changes.Changed {
+		w.jobs[job.Name] = job
+	}
+	for _, name := range changes.Removed {
+		delete(w.jobs, name)
+	}
+}
+
+// Watch polls the jobs directory every Interval until Close is called and
+// hands every non-empty set of changes to fn, which returns those it applied.
+func (w *Watcher) Watch(fn func(JobChanges) JobChanges) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			changes, err := w.Poll()
+			if errs, ok := err.(ErrorList); ok {
+				for _, e := range errs {
+					log.Printf("Failed to load job -> %v\n", e)
+				}
+			} else if err != nil {
+				log.Printf("Failed to read jobs directory %s -> %v\n", w.Root, err)
+				continue
+			}
+			if !changes.Empty() {
+				w.Applied(fn(changes))
+			}
+		}
+	}
+}
+
+func (w *Watcher) Close() {
+	close(w.quit)
+}
+
+// loadJobs loads and compiles the jobs of the job file at path.
+func loadJobs(path, root string) (jobs []*core.RPCJob, err error) {
+	var asts []*Job
+	if asts, err = LoadFile(path, root); err != nil {
+		return
+	}
+	for _, ast := range asts {
+		var job *core.RPCJob
+		if job, err = Compile(ast); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+func sortJobs(jobs []*core.RPCJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+}
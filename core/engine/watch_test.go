@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+func jobNames(jobs []*core.RPCJob) (names []string) {
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return
+}
+
+func TestWatcherPoll(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.job": razorFragment,
+		"b.job": razorFragment,
+		"c.job": razorFragment,
+	})
+	defer os.RemoveAll(root)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWatcher(root, 0)
+	jobs, err := w.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobNames(jobs); !reflect.DeepEqual(got, []string{"a.job", "b.job", "c.job"}) {
+		t.Fatalf("Load = %q", got)
+	}
+	// b.job failed to dispatch
+	w.Applied(JobChanges{Added: []*core.RPCJob{jobs[0], jobs[2]}})
+
+	changes, err := w.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobNames(changes.Added); !reflect.DeepEqual(got, []string{"b.job"}) || len(changes.Changed) > 0 || len(changes.Removed) > 0 {
+		t.Fatalf("changes after a failed dispatch = %+v, want b.job added again", changes)
+	}
+	w.Applied(changes)
+	if changes, _ = w.Poll(); !changes.Empty() {
+		t.Fatalf("changes without edits = %+v", changes)
+	}
+
+	write("a.job", "mongo RazorNodes {\n\tDatabase: razor\n\tCollection: policies\n}\n")
+	if err = os.Remove(filepath.Join(root, "c.job")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = w.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobNames(changes.Changed); !reflect.DeepEqual(got, []string{"a.job"}) || !reflect.DeepEqual(changes.Removed, []string{"c.job"}) {
+		t.Fatalf("changes = %+v, want a.job changed and c.job removed", changes)
+	}
+	// the replace failed, the withdraw succeeded
+	w.Applied(JobChanges{Removed: changes.Removed})
+	changes, _ = w.Poll()
+	if got := jobNames(changes.Changed); !reflect.DeepEqual(got, []string{"a.job"}) || len(changes.Removed) > 0 || len(changes.Added) > 0 {
+		t.Fatalf("changes after a failed replace = %+v, want a.job changed again", changes)
+	}
+	w.Applied(changes)
+
+	// a file that no longer loads keeps its jobs, its error is reported once
+	write("b.job", "mongo {\n")
+	changes, err = w.Poll()
+	if !changes.Empty() {
+		t.Errorf("changes of a broken file = %+v", changes)
+	}
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("error of a broken file = %v", err)
+	}
+	if _, err = w.Poll(); err != nil {
+		t.Errorf("error repeated by the next poll: %v", err)
+	}
+}
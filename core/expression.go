@@ -0,0 +1,206 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Expression is the content of a $(...) expression: a reference to an output
+// followed by the filters its value is piped through, as in
+// $(Task.Stdout | trim | default "none").
+type Expression struct {
+	Ref     string
+	Filters []FilterCall
+}
+
+// FilterCall is one filter of an expression with the arguments written after
+// its name.
+type FilterCall struct {
+	Name string
+	Args []string
+}
+
+func (e Expression) String() string {
+	var b strings.Builder
+	b.WriteString(e.Ref)
+	for _, f := range e.Filters {
+		b.WriteString(" | ")
+		b.WriteString(f.Name)
+		for _, a := range f.Args {
+			b.WriteString(" ")
+			b.WriteString(strconv.Quote(a))
+		}
+	}
+	return b.String()
+}
+
+// ScanExpression reads the $(...) expression text starts with and returns its
+// content without the delimiters along with the number of bytes of text it
+// takes, n is -1 when the expression is not terminated. A ) inside a quoted
+// filter argument does not end the expression. With inJSON text is the inside
+// of a JSON string, its escape sequences are decoded into content.
+func ScanExpression(text string, inJSON bool) (content string, n int) {
+	if !strings.HasPrefix(text, "$(") {
+		return "", -1
+	}
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for i := 2; i < len(text); {
+		r, w := utf8.DecodeRuneInString(text[i:])
+		if inJSON && r == '\\' {
+			r, w = decodeJSONEscape(text[i:])
+		}
+		i += w
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && r == '\\' && quote == '"':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ')':
+			return b.String(), i
+		}
+		b.WriteRune(r)
+	}
+	return "", -1
+}
+
+// decodeJSONEscape decodes the JSON escape sequence text starts with.
+func decodeJSONEscape(text string) (r rune, n int) {
+	if len(text) < 2 {
+		return '\\', 1
+	}
+	switch text[1] {
+	case '"', '\\', '/':
+		return rune(text[1]), 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+		if len(text) >= 6 {
+			if code, err := strconv.ParseUint(text[2:6], 16, 16); err == nil {
+				return rune(code), 6
+			}
+		}
+	}
+	return '\\', 1
+}
+
+// FindExpressions returns the content of every $(...) expression in text.
+func FindExpressions(text string) (contents []string) {
+	for i := strings.Index(text, "$("); i >= 0; i = strings.Index(text, "$(") {
+		content, n := ScanExpression(text[i:], false)
+		if n < 0 {
+			break
+		}
+		contents = append(contents, content)
+		text = text[i+n:]
+	}
+	return
+}
+
+// ParseExpression parses the content of a $(...) expression. Filters are
+// separated by |, their arguments are bare words or single or double-quoted
+// strings, double-quoted ones taking Go escape sequences.
+func ParseExpression(text string) (e Expression, err error) {
+	parts, err := splitPipeline(text)
+	if err != nil {
+		return
+	}
+	e.Ref = strings.TrimSpace(parts[0])
+	if len(e.Ref) == 0 {
+		return e, fmt.Errorf("expression %q names no output", text)
+	}
+	for _, part := range parts[1:] {
+		var words []string
+		if words, err = splitWords(part); err != nil {
+			return
+		}
+		if len(words) == 0 {
+			return e, fmt.Errorf("empty filter in %q", text)
+		}
+		e.Filters = append(e.Filters, FilterCall{Name: words[0], Args: words[1:]})
+	}
+	return
+}
+
+// splitPipeline splits text at every | outside quotes.
+func splitPipeline(text string) (parts []string, err error) {
+	start := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '|':
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in %q", text)
+	}
+	return append(parts, text[start:]), nil
+}
+
+// splitWords splits a filter into its name and arguments.
+func splitWords(text string) (words []string, err error) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			n := i + 1
+			for ; n < len(text) && text[n] != '"'; n++ {
+				if text[n] == '\\' {
+					n++
+				}
+			}
+			if n >= len(text) {
+				return nil, fmt.Errorf("unterminated string %s", text[i:])
+			}
+			s, err := strconv.Unquote(text[i : n+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %v", text[i:n+1], err)
+			}
+			words = append(words, s)
+			i = n + 1
+		case c == '\'':
+			n := strings.IndexByte(text[i+1:], '\'')
+			if n < 0 {
+				return nil, fmt.Errorf("unterminated string %s", text[i:])
+			}
+			words = append(words, text[i+1:i+1+n])
+			i += n + 2
+		default:
+			n := i
+			for n < len(text) && text[n] != ' ' && text[n] != '\t' && text[n] != '"' && text[n] != '\'' {
+				n++
+			}
+			words = append(words, text[i:n])
+			i = n
+		}
+	}
+	return
+}
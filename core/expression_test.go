@@ -0,0 +1,82 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		text string
+		want Expression
+		err  string
+	}{
+		{"Task.Stdout", Expression{Ref: "Task.Stdout"}, ""},
+		{" Task.Stdout | trim ", Expression{Ref: "Task.Stdout", Filters: []FilterCall{{"trim", nil}}}, ""},
+		{`Task.Stdout | trim | default "no | output"`, Expression{Ref: "Task.Stdout", Filters: []FilterCall{{"trim", nil}, {"default", []string{"no | output"}}}}, ""},
+		{`Task.Stdout | default 'it''s'`, Expression{Ref: "Task.Stdout", Filters: []FilterCall{{"default", []string{"it", "s"}}}}, ""},
+		{`Task.Stdout | default "a\tb\"c"`, Expression{Ref: "Task.Stdout", Filters: []FilterCall{{"default", []string{"a\tb\"c"}}}}, ""},
+		{`Task.Stdout | pad 10 x`, Expression{Ref: "Task.Stdout", Filters: []FilterCall{{"pad", []string{"10", "x"}}}}, ""},
+		{"| trim", Expression{}, `expression "| trim" names no output`},
+		{"Task.Stdout | | trim", Expression{}, `empty filter in "Task.Stdout | | trim"`},
+		{"Task.Stdout |", Expression{}, `empty filter in "Task.Stdout |"`},
+		{`Task.Stdout | default "open`, Expression{}, `unterminated string in "Task.Stdout | default \"open"`},
+		{`Task.Stdout | default "\q"`, Expression{}, `invalid string "\q"`},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpression(tt.text)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("ParseExpression(%q) = %+v, %v, want %s", tt.text, e, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %v", tt.text, err)
+			continue
+		}
+		if e.Ref != tt.want.Ref || len(e.Filters) != len(tt.want.Filters) {
+			t.Errorf("ParseExpression(%q) = %+v, want %+v", tt.text, e, tt.want)
+			continue
+		}
+		for i, f := range e.Filters {
+			w := tt.want.Filters[i]
+			if f.Name != w.Name || strings.Join(f.Args, ",") != strings.Join(w.Args, ",") || len(f.Args) != len(w.Args) {
+				t.Errorf("ParseExpression(%q) filter %d = %+v, want %+v", tt.text, i, f, w)
+			}
+		}
+		// String writes an expression that parses the same
+		again, err := ParseExpression(e.String())
+		if err != nil || again.String() != e.String() {
+			t.Errorf("ParseExpression(%q) = %q, %v", e.String(), again.String(), err)
+		}
+	}
+}
+
+func TestScanExpression(t *testing.T) {
+	tests := []struct {
+		text    string
+		inJSON  bool
+		content string
+		n       int
+	}{
+		{"$(Task.Stdout) rest", false, "Task.Stdout", 14},
+		{`$(Task.Stdout | default ")") rest`, false, `Task.Stdout | default ")"`, 28},
+		{`$(Task.Stdout | default 'a)b')`, false, `Task.Stdout | default 'a)b'`, 30},
+		{`$(Task.Stdout | default \"x)\")`, true, `Task.Stdout | default "x)"`, 31},
+		{`$(Task.Stdout | default "\")")`, false, `Task.Stdout | default "\")"`, 30},
+		{"$(Task.Stdout", false, "", -1},
+		{"Task.Stdout)", false, "", -1},
+	}
+	for _, tt := range tests {
+		content, n := ScanExpression(tt.text, tt.inJSON)
+		if content != tt.content || n != tt.n {
+			t.Errorf("ScanExpression(%q, %t) = %q, %d, want %q, %d", tt.text, tt.inJSON, content, n, tt.content, tt.n)
+		}
+	}
+	got := FindExpressions(`echo $(A.Stdout | trim) and $(B.Out | default ")") $(open`)
+	if want := []string{"A.Stdout | trim", `B.Out | default ")"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindExpressions = %q, want %q", got, want)
+	}
+}
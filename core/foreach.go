@@ -0,0 +1,84 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ForEach is the foreach option of a task, the task runs once for every
+// item of a JSON array:
+//
+//	foreach: $(Latest.Result)
+//
+// It holds either a $(Task.Output) reference to a JSON array, such as the
+// Result of a mongo query, or the text of an array written in the job file.
+type ForEach string
+
+func (f *ForEach) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) > 0 && b[0] == '[':
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err != nil {
+			return err
+		}
+		*f = ForEach(buf.String())
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*f = ForEach(s)
+	case bytes.Equal(b, []byte("null")):
+		*f = ""
+	default:
+		return fmt.Errorf("foreach must be an array or a $(Task.Output) reference, got %s", b)
+	}
+	return nil
+}
+
+// Check reports a foreach that is neither an array nor a reference.
+func (f ForEach) Check() error {
+	if _, ok := f.reference(); len(f) == 0 || ok {
+		return nil
+	}
+	var items []interface{}
+	if json.Unmarshal([]byte(f), &items) != nil {
+		return fmt.Errorf("foreach must be an array or a single $(Task.Output) reference, got %q", string(f))
+	}
+	return nil
+}
+
+// Items returns the items to iterate over, lookup returns the value of a
+// $(Task.Output) reference given without its delimiters. Numbers keep the
+// text they were written with.
+func (f ForEach) Items(lookup func(ref string) (interface{}, bool)) (items []interface{}, err error) {
+	text := string(f)
+	if ref, ok := f.reference(); ok {
+		v, ok := lookup(ref)
+		if !ok {
+			return nil, fmt.Errorf("foreach %s has no value", text)
+		}
+		switch v := v.(type) {
+		case []interface{}:
+			return v, nil
+		case []byte:
+			text = string(v)
+		default:
+			text = fmt.Sprint(v)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(text)))
+	dec.UseNumber()
+	if err = dec.Decode(&items); err != nil {
+		return nil, fmt.Errorf("foreach %s is not a JSON array", string(f))
+	}
+	return
+}
+
+// reference returns the content of the $(...) expression f consists of.
+func (f ForEach) reference() (string, bool) {
+	content, n := ScanExpression(string(f), false)
+	return content, n == len(f) && len(content) > 0
+}
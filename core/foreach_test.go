@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestForEachJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want ForEach
+		err  bool
+	}{
+		{`[1, "two",  {"x": 3}]`, `[1,"two",{"x":3}]`, false},
+		{`"$(Latest.Result)"`, `$(Latest.Result)`, false},
+		{`null`, ``, false},
+		{`42`, ``, true},
+		{`{"a": 1}`, ``, true},
+	}
+	for _, tt := range tests {
+		var f ForEach
+		err := json.Unmarshal([]byte(tt.json), &f)
+		if (err != nil) != tt.err || f != tt.want {
+			t.Errorf("Unmarshal(%s) = %q, %v, want %q", tt.json, f, err, tt.want)
+		}
+	}
+}
+
+func TestForEachCheck(t *testing.T) {
+	tests := []struct {
+		f  ForEach
+		ok bool
+	}{
+		{``, true},
+		{`[1,2]`, true},
+		{`$(Latest.Result)`, true},
+		{`$(Latest.Result | default: [])`, true},
+		{`$(A.Out) $(B.Out)`, false},
+		{`items: $(A.Out)`, false},
+		{`nodes`, false},
+	}
+	for _, tt := range tests {
+		if err := tt.f.Check(); (err == nil) != tt.ok {
+			t.Errorf("Check(%q) = %v, want ok %t", tt.f, err, tt.ok)
+		}
+	}
+}
+
+func TestForEachItems(t *testing.T) {
+	state := map[string]interface{}{
+		"Latest.Result": []byte(`[{"name": "a"}, {"name": "b"}]`),
+		"Split.Items":   []interface{}{"x", "y"},
+		"Echo.Stdout":   `["1.50", 2]`,
+		"Echo.Stderr":   "oops",
+	}
+	lookup := func(ref string) (interface{}, bool) {
+		v, ok := state[ref]
+		return v, ok
+	}
+	tests := []struct {
+		f    ForEach
+		want string
+		err  string
+	}{
+		{`[1.0, "two", {"x": 3}]`, `[1.0 two map[x:3]]`, ""},
+		{`$(Latest.Result)`, `[map[name:a] map[name:b]]`, ""},
+		{`$(Split.Items)`, `[x y]`, ""},
+		{`$(Echo.Stdout)`, `[1.50 2]`, ""},
+		{`[]`, `[]`, ""},
+		{`$(Echo.Stderr)`, "", "foreach $(Echo.Stderr) is not a JSON array"},
+		{`$(Missing.Output)`, "", "foreach $(Missing.Output) has no value"},
+	}
+	for _, tt := range tests {
+		items, err := tt.f.Items(lookup)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Items(%q) = %v, %v, want %s", tt.f, items, err, tt.err)
+			}
+			continue
+		}
+		if got := fmt.Sprint(items); err != nil || got != tt.want {
+			t.Errorf("Items(%q) = %s, %v, want %s", tt.f, got, err, tt.want)
+		}
+	}
+}
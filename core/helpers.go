@@ -2,57 +2,38 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
 )
 
+// JSONPromote merges an array of single-key objects into one object, which is
+// how older engines encoded resource properties. Objects are returned as-is.
 // input:  [ {"a":"value"},{"b":"value"} ]
 // result: { "a":"value", "b":"value" }
 func JSONPromote(data []byte) []byte {
-	var insideQuotes bool
-	var braceDepth int
-	var parts []string
-	var lastPart int
-	var buf bytes.Buffer
-	var d []byte
-
-	if data[0] == '[' && data[len(data)-1] == ']' {
-		d = data[1 : len(data)-1]
-	} else {
-		d = data
+	d := bytes.TrimSpace(data)
+	if len(d) == 0 {
+		return []byte("{}")
 	}
-	for i, r := range d {
-		if r == '"' {
-			insideQuotes = !insideQuotes
-		}
-		if r == '{' {
-			braceDepth++
-		}
-		if r == '}' {
-			braceDepth--
-		}
-		if r == ',' && !insideQuotes && braceDepth == 0 {
-			parts = append(parts, string(d[lastPart:i]))
-			lastPart = i + 1
-		}
+	if d[0] != '[' {
+		return d
 	}
-	if lastPart > 0 {
-		parts = append(parts, string(d[lastPart:len(d)]))
+	var parts []map[string]json.RawMessage
+	if err := json.Unmarshal(d, &parts); err != nil {
+		return d
 	}
-	if lastPart == 0 {
-		parts = append(parts, string(d))
-	}
-	buf.WriteByte('{')
-	for i, p := range parts {
-		if i > 0 {
-			buf.WriteByte(',')
-		}
-		if p[0] == '{' && p[len(p)-1] == '}' {
-			buf.WriteString(p[1 : len(p)-1])
-			continue
+	merged := make(map[string]json.RawMessage)
+	for _, p := range parts {
+		for k, v := range p {
+			merged[k] = v
 		}
-		buf.WriteString(p)
 	}
-	buf.WriteByte('}')
-	return buf.Bytes()
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return d
+	}
+	return b
 }
 
 func JSONEscape(data string) string {
@@ -87,8 +68,59 @@ func JSONEscape(data string) string {
 		case 92:
 			buf.WriteString(`\\`)
 		default:
+			if b < 32 {
+				buf.WriteString(fmt.Sprintf(`\u%04x`, b))
+				continue
+			}
 			buf.WriteRune(b)
 		}
 	}
 	return buf.String()
 }
+
+// referenceSyntax matches the ${env:NAME}, ${secret:name} and ${param:name}
+// references. Anything else written as ${...}, such as ${VAR:-default} in a
+// shell script, is not a reference and is left as it is.
+var referenceSyntax = regexp.MustCompile(`\$\{(env|secret|param):([^}]*)\}`)
+
+var referencePrefix = regexp.MustCompile(`^` + referenceSyntax.String())
+
+// FindReferences returns the ${scheme:name} references in data, each as its
+// text, scheme and name.
+func FindReferences(data string) [][]string {
+	return referenceSyntax.FindAllStringSubmatch(data, -1)
+}
+
+// ScanReference reads the ${scheme:name} reference text starts with and
+// returns its scheme and name along with the number of bytes of text it
+// takes, n is -1 when text does not start with a reference.
+func ScanReference(text string) (scheme, name string, n int) {
+	m := referencePrefix.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", -1
+	}
+	return m[1], m[2], len(m[0])
+}
+
+// ExpandReferences replaces the ${scheme:name} references in data with the
+// value returned by fn. References fn does not handle (ok is false) are left
+// untouched so they can be resolved later on, e.g. by the runner.
+func ExpandReferences(data string, fn func(scheme, name string) (value string, ok bool, err error)) (string, error) {
+	var expandErr error
+	out := referenceSyntax.ReplaceAllStringFunc(data, func(ref string) string {
+		m := referenceSyntax.FindStringSubmatch(ref)
+		if expandErr != nil {
+			return ref
+		}
+		value, ok, err := fn(m[1], m[2])
+		if err != nil {
+			expandErr = err
+			return ref
+		}
+		if !ok {
+			return ref
+		}
+		return value
+	})
+	return out, expandErr
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanReference(t *testing.T) {
+	tests := []struct {
+		text         string
+		scheme, name string
+		n            int
+	}{
+		{"${env:HOME}", "env", "HOME", 11},
+		{"${secret:db_pass} rest", "secret", "db_pass", 17},
+		{"${param:Collection}", "param", "Collection", 19},
+		{"${VAR:-default}", "", "", -1},
+		{"${HOME}", "", "", -1},
+		{"${env:HOME", "", "", -1},
+		{"x ${env:HOME}", "", "", -1},
+	}
+	for _, tt := range tests {
+		scheme, name, n := ScanReference(tt.text)
+		if scheme != tt.scheme || name != tt.name || n != tt.n {
+			t.Errorf("ScanReference(%q) = %q, %q, %d, want %q, %q, %d", tt.text, scheme, name, n, tt.scheme, tt.name, tt.n)
+		}
+	}
+}
+
+func TestExpandReferences(t *testing.T) {
+	lookup := func(scheme, name string) (string, bool, error) {
+		switch scheme {
+		case "env":
+			return "<" + name + ">", true, nil
+		case "secret":
+			return "", false, fmt.Errorf("secret %s not found", name)
+		}
+		return "", false, nil
+	}
+	tests := []struct {
+		data, want string
+		err        bool
+	}{
+		{"plain", "plain", false},
+		{"${env:A} and ${env:B}", "<A> and <B>", false},
+		{"${param:P} stays", "${param:P} stays", false},
+		{"echo ${VAR:-default} ${env:A}", "echo ${VAR:-default} <A>", false},
+		{"${secret:s}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandReferences(tt.data, lookup)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ExpandReferences(%q) = %q, want an error", tt.data, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandReferences(%q) = %q, %v, want %q", tt.data, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseJSONSegment is the path segment that parses a text output as JSON,
+// as in $(Listen.Body.json.user.id).
+const ParseJSONSegment = "json"
+
+// PathSegment selects a field of an object or, when IsIndex is set, an item
+// of an array.
+type PathSegment struct {
+	Field   string
+	Index   int
+	IsIndex bool
+}
+
+func (s PathSegment) String() string {
+	if s.IsIndex {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	if strings.ContainsAny(s.Field, ".[]") {
+		return fmt.Sprintf("[%q]", s.Field)
+	}
+	return "." + s.Field
+}
+
+// Path selects a value inside a JSON document, written as a sequence of
+// .field, [index] and ["field"] segments such as [0].hostname.
+type Path []PathSegment
+
+func (p Path) String() string {
+	var b strings.Builder
+	for _, s := range p {
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+// ParsePath parses the path text, the empty path selects the whole document.
+func ParsePath(text string) (p Path, err error) {
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '.':
+			j := i + 1
+			for j < len(text) && text[j] != '.' && text[j] != '[' {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("empty field name")
+			}
+			if strings.Contains(text[i+1:j], "]") {
+				return nil, fmt.Errorf("unexpected ] after %s", text[i+1:j])
+			}
+			p = append(p, PathSegment{Field: text[i+1 : j]})
+			i = j
+		case '[':
+			j := strings.Index(text[i:], "]")
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated [%s", text[i+1:])
+			}
+			inner := text[i+1 : i+j]
+			if len(inner) > 0 && (inner[0] == '"' || inner[0] == '\'') {
+				if len(inner) < 2 || inner[len(inner)-1] != inner[0] {
+					return nil, fmt.Errorf("unterminated quoted field [%s]", inner)
+				}
+				p = append(p, PathSegment{Field: inner[1 : len(inner)-1]})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index [%s], expecting a number from 0", inner)
+				}
+				p = append(p, PathSegment{Index: n, IsIndex: true})
+			}
+			i += j + 1
+		default:
+			return nil, fmt.Errorf("unexpected %q, expecting . or [", text[i:])
+		}
+	}
+	return
+}
+
+// ReferenceKeys returns the keys a $(...) reference may name, longest first:
+// the reference itself and every prefix ending before a . or [, the rest of
+// the reference being a Path into the value of the key.
+func ReferenceKeys(ref string) (keys []string) {
+	keys = append(keys, ref)
+	for i := len(ref) - 1; i > 0; i-- {
+		if ref[i] == '.' || ref[i] == '[' {
+			keys = append(keys, ref[:i])
+		}
+	}
+	return
+}
+
+// ParseDocument decodes the JSON document doc for Select, numbers keep the
+// text they were written with.
+func ParseDocument(doc []byte) (v interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("data after the end of the document")
+	}
+	return
+}
+
+// Select returns the value p selects in the document v, decoded by
+// ParseDocument. name is the reference to v used in errors.
+func (p Path) Select(name string, v interface{}) (interface{}, error) {
+	for _, s := range p {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			if s.IsIndex {
+				return nil, fmt.Errorf("%s is an object, not an array", name)
+			}
+			fv, ok := x[s.Field]
+			if !ok {
+				return nil, fmt.Errorf("%s has no field %s", name, s.Field)
+			}
+			v = fv
+		case []interface{}:
+			if !s.IsIndex {
+				return nil, fmt.Errorf("%s is an array, not an object", name)
+			}
+			if s.Index >= len(x) {
+				return nil, fmt.Errorf("%s[%d] is out of range, the length of %s is %d", name, s.Index, name, len(x))
+			}
+			v = x[s.Index]
+		default:
+			return nil, fmt.Errorf("%s is %s, it has no %s", name, jsonType(v), s)
+		}
+		name += s.String()
+	}
+	return v, nil
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a bool"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		text string
+		want Path
+		err  string
+	}{
+		{text: "", want: nil},
+		{text: "[0].hostname", want: Path{{Index: 0, IsIndex: true}, {Field: "hostname"}}},
+		{text: `.json.user["first.name"]`, want: Path{{Field: "json"}, {Field: "user"}, {Field: "first.name"}}},
+		{text: "['a'][12]", want: Path{{Field: "a"}, {Index: 12, IsIndex: true}}},
+		{text: "..a", err: "empty field name"},
+		{text: "[1", err: "unterminated [1"},
+		{text: `["a]`, err: "unterminated quoted field"},
+		{text: "[-1]", err: "invalid index [-1]"},
+		{text: "[x]", err: "invalid index [x]"},
+		{text: "a", err: "expecting . or ["},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.text)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParsePath(%q) error = %v, want %q", tt.text, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(p, tt.want) {
+			t.Errorf("ParsePath(%q) = %v, %v, want %v", tt.text, p, err, tt.want)
+		}
+	}
+}
+
+func TestPathString(t *testing.T) {
+	for _, text := range []string{"[0].hostname", `.user["first.name"][3]`} {
+		p, err := ParsePath(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != text {
+			t.Errorf("ParsePath(%q).String() = %q", text, p.String())
+		}
+	}
+}
+
+func TestPathSelect(t *testing.T) {
+	doc, err := ParseDocument([]byte(`{"nodes":[{"hostname":"a","tags":["x"]},{"hostname":"b","size":2}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want interface{}
+		err  string
+	}{
+		{path: ".nodes[1].hostname", want: "b"},
+		{path: ".nodes[0].tags[0]", want: "x"},
+		{path: ".nodes[2]", err: "Nodes.Result.nodes[2] is out of range, the length of Nodes.Result.nodes is 2"},
+		{path: ".nodes[0].missing", err: "Nodes.Result.nodes[0] has no field missing"},
+		{path: ".nodes.hostname", err: "Nodes.Result.nodes is an array, not an object"},
+		{path: "[0]", err: "Nodes.Result is an object, not an array"},
+		{path: ".nodes[0].hostname.x", err: "Nodes.Result.nodes[0].hostname is a string, it has no .x"},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := p.Select("Nodes.Result", doc)
+		if len(tt.err) > 0 {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Select(%s) error = %v, want %q", tt.path, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || v != tt.want {
+			t.Errorf("Select(%s) = %v, %v, want %v", tt.path, v, err, tt.want)
+		}
+	}
+}
+
+func TestReferenceKeys(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want []string
+	}{
+		{"Nodes.Result", []string{"Nodes.Result", "Nodes"}},
+		{"Nodes.Result[0].hostname", []string{"Nodes.Result[0].hostname", "Nodes.Result[0]", "Nodes.Result", "Nodes"}},
+		{"item", []string{"item"}},
+	}
+	for _, tt := range tests {
+		if got := ReferenceKeys(tt.ref); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReferenceKeys(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
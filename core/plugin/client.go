@@ -0,0 +1,392 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kozical/taskengine/core/runner"
+)
+
+// HandshakeTimeout bounds the time a plugin may take to answer the
+// handshake.
+var HandshakeTimeout = 10 * time.Second
+
+// FilterTimeout bounds the time a plugin may take to apply a filter.
+var FilterTimeout = 10 * time.Second
+
+var errExited = errors.New("plugin exited")
+
+// Plugin is a running plugin executable.
+type Plugin struct {
+	Path string
+	Info Info
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	muEnc sync.Mutex
+	enc   *json.Encoder
+
+	muPending sync.Mutex
+	nextID    int64
+	pending   map[int64]chan *Message
+	events    map[int64]func(*Message)
+
+	// done is closed once the plugin stopped answering, err says why
+	done chan struct{}
+	err  error
+}
+
+// Start runs the plugin executable at path and performs the handshake.
+func Start(path string) (p *Plugin, err error) {
+	p = &Plugin{
+		Path:    path,
+		cmd:     exec.Command(path),
+		pending: make(map[int64]chan *Message),
+		events:  make(map[int64]func(*Message)),
+		done:    make(chan struct{}),
+	}
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go p.logStderr(stderr)
+
+	p.enc = json.NewEncoder(p.stdin)
+	dec := json.NewDecoder(stdout)
+	if err = p.handshake(dec); err != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+		return nil, fmt.Errorf("plugin %s: %v", path, err)
+	}
+	go p.read(dec)
+	return p, nil
+}
+
+func (p *Plugin) handshake(dec *json.Decoder) error {
+	if err := p.send(&Message{Type: TypeHandshake, Version: Version}); err != nil {
+		return err
+	}
+	reply := make(chan error, 1)
+	var m Message
+	go func() {
+		reply <- dec.Decode(&m)
+	}()
+	select {
+	case err := <-reply:
+		if err != nil {
+			return fmt.Errorf("reading handshake: %v", err)
+		}
+	case <-time.After(HandshakeTimeout):
+		return fmt.Errorf("no handshake within %s", HandshakeTimeout)
+	}
+	switch {
+	case m.Type != TypeHandshake:
+		return fmt.Errorf("expecting a handshake, got %s", m.Type)
+	case m.Version != Version:
+		return fmt.Errorf("plugin speaks protocol version %d, expecting %d", m.Version, Version)
+	case m.Info == nil || len(m.Info.Name) == 0:
+		return fmt.Errorf("handshake names no provider")
+	}
+	p.Info = *m.Info
+	return nil
+}
+
+func (p *Plugin) logStderr(r io.Reader) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		log.Printf("plugin %s: %s\n", filepath.Base(p.Path), s.Text())
+	}
+}
+
+// read hands the responses of the plugin to the requests waiting for them
+// until the plugin exits.
+func (p *Plugin) read(dec *json.Decoder) {
+	for {
+		m := new(Message)
+		if err := dec.Decode(m); err != nil {
+			if err == io.EOF {
+				err = errExited
+			}
+			p.muPending.Lock()
+			p.err = err
+			close(p.done)
+			p.muPending.Unlock()
+			return
+		}
+		p.muPending.Lock()
+		switch m.Type {
+		case TypeResult:
+			if c, ok := p.pending[m.ID]; ok {
+				delete(p.pending, m.ID)
+				c <- m
+			}
+		case TypeEvent:
+			if fn, ok := p.events[m.ID]; ok {
+				go fn(m)
+			}
+		default:
+			log.Printf("plugin %s sent an unexpected %s message\n", p.Info.Name, m.Type)
+		}
+		p.muPending.Unlock()
+	}
+}
+
+func (p *Plugin) send(m *Message) error {
+	p.muEnc.Lock()
+	defer p.muEnc.Unlock()
+	return p.enc.Encode(m)
+}
+
+// call sends the request m and waits for its result. When ctx is done first
+// the request is cancelled.
+func (p *Plugin) call(ctx context.Context, m *Message) (*Message, error) {
+	c := make(chan *Message, 1)
+	p.muPending.Lock()
+	if p.err != nil {
+		p.muPending.Unlock()
+		return nil, p.err
+	}
+	if m.ID == 0 {
+		p.nextID++
+		m.ID = p.nextID
+	}
+	p.pending[m.ID] = c
+	p.muPending.Unlock()
+
+	if err := p.send(m); err != nil {
+		p.forget(m.ID)
+		return nil, err
+	}
+	select {
+	case r := <-c:
+		return r, nil
+	case <-ctx.Done():
+		p.forget(m.ID)
+		p.send(&Message{Type: TypeCancel, ID: m.ID})
+		return nil, ctx.Err()
+	case <-p.done:
+		return nil, p.err
+	}
+}
+
+func (p *Plugin) forget(id int64) {
+	p.muPending.Lock()
+	delete(p.pending, id)
+	p.muPending.Unlock()
+}
+
+// Close asks the plugin to exit by closing its stdin and kills it when it
+// has not done so within a few seconds. Its stdout is read to the end before
+// waiting for it, so requests still in progress fail with the plugin exited
+// error.
+func (p *Plugin) Close() error {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	return p.cmd.Wait()
+}
+
+// Provider returns the provider running the tasks of the plugin.
+func (p *Plugin) Provider() runner.Provider {
+	return &provider{p: p}
+}
+
+// ProviderInfo returns the registry entry of the plugin.
+func (p *Plugin) ProviderInfo() runner.ProviderInfo {
+	provider := p.Provider()
+	return runner.ProviderInfo{
+		Name:    p.Info.Name,
+		Aliases: p.Info.Aliases,
+		Version: p.Info.Version,
+		Schema:  p.Info.Schema,
+		New:     func() (runner.Provider, error) { return provider, nil },
+	}
+}
+
+type provider struct {
+	p *Plugin
+}
+
+func (pp *provider) String() string {
+	return fmt.Sprintf("plugin %s", pp.p.Info.Name)
+}
+
+func (pp *provider) Execute(j *runner.Job) error {
+	return pp.ExecuteContext(j.Context(), j, j.Task())
+}
+
+// ExecuteContext sends the interpolated properties of task to the plugin and
+// stores the outputs of the result.
+func (pp *provider) ExecuteContext(ctx context.Context, j *runner.Job, task *runner.Task) error {
+	if task == nil {
+		return fmt.Errorf("plugin %s received a nil task", pp.p.Info.Name)
+	}
+	properties, err := j.InterpolateState(string(task.Properties))
+	if err != nil {
+		return err
+	}
+	res, err := pp.p.call(ctx, &Message{
+		Type:       TypeExecute,
+		Task:       task.Title,
+		Properties: properties,
+	})
+	if err != nil {
+		return err
+	}
+	storeOutputs(j, task, res.Outputs)
+	return resultError(res)
+}
+
+// Register starts the jobs of a plugin that sets Events, once for every
+// event it sends until ctx is done.
+func (pp *provider) Register(ctx context.Context, task *runner.Task, fn func() *runner.Job) {
+	if !pp.p.Info.Events {
+		return
+	}
+	p := pp.p
+	// the properties may hold secrets, errors are redacted with this job
+	j := fn()
+	properties, err := j.InterpolateState(string(task.Properties))
+	if err != nil {
+		log.Printf("Registering %s with plugin %s failed -> %s\n", task.Title, p.Info.Name, j.Redact(err.Error()))
+		return
+	}
+	p.muPending.Lock()
+	p.nextID++
+	id := p.nextID
+	p.events[id] = func(m *Message) {
+		j := fn()
+		storeOutputs(j, task, m.Outputs)
+		j.Run()
+	}
+	p.muPending.Unlock()
+	defer func() {
+		p.muPending.Lock()
+		delete(p.events, id)
+		p.muPending.Unlock()
+	}()
+
+	res, err := p.call(ctx, &Message{
+		Type:       TypeRegister,
+		ID:         id,
+		Task:       task.Title,
+		Properties: properties,
+	})
+	if err == nil {
+		err = resultError(res)
+	}
+	if err != nil {
+		log.Printf("Registering %s with plugin %s failed -> %s\n", task.Title, p.Info.Name, j.Redact(err.Error()))
+		return
+	}
+	select {
+	case <-ctx.Done():
+		p.send(&Message{Type: TypeUnregister, ID: id})
+	case <-p.done:
+	}
+}
+
+// Filter returns the expression filter name of the plugin.
+func (p *Plugin) Filter(name string) runner.Filter {
+	return func(v runner.Value, args []string) (runner.Value, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), FilterTimeout)
+		defer cancel()
+		res, err := p.call(ctx, &Message{Type: TypeFilter, Filter: name, Value: v.String(), Args: args})
+		if err != nil {
+			return runner.Value{}, err
+		}
+		if err = resultError(res); err != nil {
+			return runner.Value{}, err
+		}
+		return runner.StringValue(res.Value), nil
+	}
+}
+
+func storeOutputs(j *runner.Job, task *runner.Task, outputs map[string]string) {
+	for k, v := range outputs {
+		j.Publish(task, k, v)
+	}
+}
+
+func resultError(m *Message) error {
+	if len(m.Error) == 0 {
+		return nil
+	}
+	err := errors.New(m.Error)
+	if len(m.Class) > 0 {
+		return runner.ClassifyError(m.Class, err)
+	}
+	return err
+}
+
+// Discover starts every executable in dir, hidden files are skipped. Plugins
+// that fail to start are returned as errors, the others keep running.
+func Discover(dir string) (plugins []*Plugin, errs []error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") || !f.Mode().IsRegular() || f.Mode()&0111 == 0 {
+			continue
+		}
+		p, err := Start(filepath.Join(dir, f.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		plugins = append(plugins, p)
+	}
+	return
+}
+
+// Register discovers the plugins in dir and registers their providers with
+// r and their filters. Plugins that fail to start or whose name is taken are
+// returned as errors and are not registered, filters whose name is taken are
+// reported and left out.
+func Register(r *runner.Runner, dir string) (plugins []*Plugin, errs []error) {
+	started, errs := Discover(dir)
+	for _, p := range started {
+		if err := r.Register(p.ProviderInfo()); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %v", p.Path, err))
+			p.Close()
+			continue
+		}
+		for _, name := range p.Info.Filters {
+			if err := runner.RegisterFilter(name, p.Filter(name)); err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s: %v", p.Path, err))
+			}
+		}
+		plugins = append(plugins, p)
+	}
+	return
+}
@@ -0,0 +1,444 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core/runner"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+)
+
+// pluginEnv makes the test binary run as a plugin, see TestMain.
+const pluginEnv = "TASKENGINE_TEST_PLUGIN"
+
+var testInfo = Info{Name: "upper", Version: "1.0.0", Filters: []string{"rot13"}}
+
+// upper publishes its Text property in upper case as Text. It fails with
+// Class when Fail is set and waits to be cancelled when Block is set.
+type upper struct {
+	unregistered chan string
+}
+
+type upperSettings struct {
+	Text  string
+	Fail  bool
+	Class string
+	Block bool
+	Count int
+}
+
+func (upper) Execute(ctx context.Context, req *Request) (map[string]string, error) {
+	var s upperSettings
+	if err := req.Decode(&s); err != nil {
+		return nil, err
+	}
+	switch {
+	case s.Block:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case s.Fail && len(s.Class) > 0:
+		return nil, runner.ClassifyError(s.Class, errors.New("failed "+req.Task))
+	case s.Fail:
+		return nil, errors.New("failed " + req.Task)
+	}
+	return map[string]string{"Text": strings.ToUpper(s.Text)}, nil
+}
+
+// Register emits Count events, then waits to be unregistered. It fails with
+// Text when Fail is set.
+func (u upper) Register(ctx context.Context, req *Request, emit func(outputs map[string]string)) error {
+	var s upperSettings
+	if err := req.Decode(&s); err != nil {
+		return err
+	}
+	if s.Fail {
+		return errors.New("failed " + s.Text)
+	}
+	if s.Count < 0 {
+		return fmt.Errorf("invalid count %d", s.Count)
+	}
+	go func() {
+		for i := 0; i < s.Count; i++ {
+			emit(map[string]string{"Body": fmt.Sprintf("event-%d", i)})
+		}
+		<-ctx.Done()
+		if u.unregistered != nil {
+			u.unregistered <- req.Task
+		}
+	}()
+	return nil
+}
+
+func (upper) Filter(name, value string, args []string) (string, error) {
+	if name != "rot13" {
+		return "", fmt.Errorf("unknown filter %s", name)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, value), nil
+}
+
+// plain is a handler that neither starts jobs nor has filters.
+type plain struct{}
+
+func (plain) Execute(ctx context.Context, req *Request) (map[string]string, error) {
+	return nil, nil
+}
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(pluginEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "serve":
+		if err := Serve(testInfo, upper{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "version":
+		json.NewDecoder(os.Stdin).Decode(new(Message))
+		json.NewEncoder(os.Stdout).Encode(&Message{Type: TypeHandshake, Version: Version + 1, Info: &testInfo})
+		io.Copy(ioutil.Discard, os.Stdin)
+	case "silent":
+		io.Copy(ioutil.Discard, os.Stdin)
+	}
+	os.Exit(0)
+}
+
+// conn runs ServeConn for h and returns the encoder of its requests and the
+// decoder of its responses. Closing the encoder's writer ends ServeConn,
+// whose error is sent on the returned channel.
+type conn struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+	dec *json.Decoder
+	err chan error
+}
+
+func serve(t *testing.T, h Handler) *conn {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &conn{w: inW, enc: json.NewEncoder(inW), dec: json.NewDecoder(outR), err: make(chan error, 1)}
+	go func() {
+		c.err <- ServeConn(inR, outW, testInfo, h)
+		outW.Close()
+	}()
+	c.send(t, &Message{Type: TypeHandshake, Version: Version})
+	return c
+}
+
+func (c *conn) send(t *testing.T, m *Message) {
+	t.Helper()
+	if err := c.enc.Encode(m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *conn) receive(t *testing.T) *Message {
+	t.Helper()
+	m := new(Message)
+	if err := c.dec.Decode(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestServeConn(t *testing.T) {
+	unregistered := make(chan string, 1)
+	c := serve(t, upper{unregistered})
+	if m := c.receive(t); m.Type != TypeHandshake || m.Version != Version || m.Info == nil || m.Info.Name != "upper" || !m.Info.Events {
+		t.Fatalf("handshake = %+v", m)
+	}
+
+	tests := []struct {
+		name string
+		req  Message
+		want Message
+	}{
+		{
+			name: "execute",
+			req:  Message{Type: TypeExecute, ID: 1, Task: "Up", Properties: json.RawMessage(`{"Text":"hi"}`)},
+			want: Message{Type: TypeResult, ID: 1, Outputs: map[string]string{"Text": "HI"}},
+		},
+		{
+			name: "error",
+			req:  Message{Type: TypeExecute, ID: 2, Task: "Up", Properties: json.RawMessage(`{"Fail":true}`)},
+			want: Message{Type: TypeResult, ID: 2, Error: "failed Up"},
+		},
+		{
+			name: "error class",
+			req:  Message{Type: TypeExecute, ID: 3, Task: "Up", Properties: json.RawMessage(`{"Fail":true,"Class":"busy"}`)},
+			want: Message{Type: TypeResult, ID: 3, Error: "failed Up", Class: "busy"},
+		},
+		{
+			name: "invalid properties",
+			req:  Message{Type: TypeExecute, ID: 4, Task: "Up", Properties: json.RawMessage(`{"Text":1}`)},
+			want: Message{Type: TypeResult, ID: 4, Error: "json: cannot unmarshal number into Go struct field upperSettings.Text of type string"},
+		},
+		{
+			name: "filter",
+			req:  Message{Type: TypeFilter, ID: 5, Filter: "rot13", Value: "Hello"},
+			want: Message{Type: TypeResult, ID: 5, Value: "Uryyb"},
+		},
+		{
+			name: "unknown filter",
+			req:  Message{Type: TypeFilter, ID: 6, Filter: "rot47", Value: "Hello"},
+			want: Message{Type: TypeResult, ID: 6, Error: "unknown filter rot47"},
+		},
+		{
+			name: "register error",
+			req:  Message{Type: TypeRegister, ID: 7, Task: "Hook", Properties: json.RawMessage(`{"Count":-1}`)},
+			want: Message{Type: TypeResult, ID: 7, Error: "invalid count -1"},
+		},
+	}
+	for _, tt := range tests {
+		c.send(t, &tt.req)
+		if got := c.receive(t); !equal(got, &tt.want) {
+			t.Errorf("%s: response = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// a cancel ends a request in progress
+	c.send(t, &Message{Type: TypeExecute, ID: 8, Task: "Up", Properties: json.RawMessage(`{"Block":true}`)})
+	c.send(t, &Message{Type: TypeCancel, ID: 8})
+	if got := c.receive(t); got.ID != 8 || got.Error != context.Canceled.Error() {
+		t.Errorf("cancelled request = %+v", got)
+	}
+
+	// events carry the id of their register request until unregistered
+	c.send(t, &Message{Type: TypeRegister, ID: 9, Task: "Hook", Properties: json.RawMessage(`{"Count":2}`)})
+	var results, events []string
+	for len(results)+len(events) < 3 {
+		m := c.receive(t)
+		if m.ID != 9 || len(m.Error) > 0 {
+			t.Fatalf("register response = %+v", m)
+		}
+		switch m.Type {
+		case TypeResult:
+			results = append(results, m.Type)
+		case TypeEvent:
+			events = append(events, m.Outputs["Body"])
+		}
+	}
+	if len(results) != 1 || strings.Join(events, ",") != "event-0,event-1" {
+		t.Errorf("results %q, events %q", results, events)
+	}
+	c.send(t, &Message{Type: TypeUnregister, ID: 9})
+	select {
+	case task := <-unregistered:
+		if task != "Hook" {
+			t.Errorf("unregistered %s", task)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("unregister did not end the registration")
+	}
+
+	c.w.Close()
+	if err := <-c.err; err != nil {
+		t.Errorf("ServeConn = %v after stdin was closed", err)
+	}
+}
+
+func TestServeConnPlain(t *testing.T) {
+	c := serve(t, plain{})
+	if m := c.receive(t); m.Info == nil || m.Info.Events {
+		t.Fatalf("handshake = %+v, want a plugin that does not start jobs", m)
+	}
+	c.send(t, &Message{Type: TypeRegister, ID: 1, Task: "Hook"})
+	if m := c.receive(t); m.ID != 1 || m.Error != "upper does not start jobs" {
+		t.Errorf("register = %+v", m)
+	}
+	c.send(t, &Message{Type: TypeFilter, ID: 2, Filter: "rot13"})
+	if m := c.receive(t); m.ID != 2 || m.Error != "upper has no filters" {
+		t.Errorf("filter = %+v", m)
+	}
+	c.w.Close()
+	if err := <-c.err; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestServeConnHandshake(t *testing.T) {
+	err := ServeConn(strings.NewReader(`{"type":"execute","id":1}`+"\n"), ioutil.Discard, testInfo, plain{})
+	if err == nil || err.Error() != "expecting a handshake, got execute" {
+		t.Errorf("ServeConn = %v", err)
+	}
+}
+
+func equal(a, b *Message) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// start runs the test binary as a plugin behaving as mode.
+func start(t *testing.T, mode string) (*Plugin, error) {
+	t.Helper()
+	os.Setenv(pluginEnv, mode)
+	defer os.Unsetenv(pluginEnv)
+	return Start(os.Args[0])
+}
+
+func TestPlugin(t *testing.T) {
+	p, err := start(t, "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.Info.Name != "upper" || !p.Info.Events || strings.Join(p.Info.Filters, ",") != "rot13" {
+		t.Errorf("Info = %+v", p.Info)
+	}
+	info := p.ProviderInfo()
+	if info.Name != "upper" || info.Version != "1.0.0" {
+		t.Errorf("ProviderInfo = %+v", info)
+	}
+
+	var h providertest.Harness
+	h.State = map[string]interface{}{"In.Body": "from state"}
+	j, err := h.Execute(context.Background(), providertest.Task(p.Provider(), "Up", `{"Text":"$(In.Body)"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := j.Load("Up.Text"); out != "FROM STATE" {
+		t.Errorf("Up.Text = %v", out)
+	}
+
+	_, err = h.Execute(context.Background(), providertest.Task(p.Provider(), "Up", `{"Fail":true,"Class":"busy"}`))
+	if err == nil || err.Error() != "failed Up" || runner.ErrorClass(err) != "busy" {
+		t.Errorf("error = %v of class %s", err, runner.ErrorClass(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = h.Execute(ctx, providertest.Task(p.Provider(), "Up", `{"Block":true}`)); runner.ErrorClass(err) != runner.ClassTimeout {
+		t.Errorf("error of a cancelled request = %v", err)
+	}
+	// the plugin keeps serving after a cancelled request
+	if _, err = h.Execute(context.Background(), providertest.Task(p.Provider(), "Up", `{}`)); err != nil {
+		t.Error(err)
+	}
+
+	v, err := p.Filter("rot13")(runner.StringValue("Hello"), nil)
+	if err != nil || v.String() != "Uryyb" {
+		t.Errorf("rot13 = %q, %v", v.String(), err)
+	}
+	if _, err = p.Filter("rot47")(runner.StringValue("Hello"), nil); err == nil {
+		t.Error("an unknown filter did not fail")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	e := h.Register(ctx, providertest.Task(p.Provider(), "Hook", `{"Count":2}`))
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		j := e.NextWithin(5 * time.Second)
+		if j == nil {
+			t.Fatalf("only %d of 2 events started a job", i)
+		}
+		body, _ := j.Load("Hook.Body")
+		bodies = append(bodies, fmt.Sprint(body))
+	}
+	cancel()
+	e.Close()
+	if strings.Join(bodies, ",") != "event-0,event-1" && strings.Join(bodies, ",") != "event-1,event-0" {
+		t.Errorf("event bodies = %q", bodies)
+	}
+
+	if err = p.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+	if _, err = h.Execute(context.Background(), providertest.Task(p.Provider(), "Up", `{}`)); err != errExited {
+		t.Errorf("error after Close = %v, want %v", err, errExited)
+	}
+}
+
+// logBuffer collects log output while it is written.
+type logBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+// secrets is a SecretStore holding its secrets in memory.
+type secrets map[string]string
+
+func (s secrets) Secret(name string) (string, error) {
+	v, ok := s[name]
+	if !ok {
+		return "", runner.ErrSecretNotFound
+	}
+	return v, nil
+}
+
+func TestRegisterRedactsSecrets(t *testing.T) {
+	p, err := start(t, "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	buf := new(logBuffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	h := providertest.Harness{Secrets: secrets{"pw": "hunter2"}}
+	e := h.Register(context.Background(), providertest.Task(p.Provider(), "Hook", `{"Fail":true,"Text":"${secret:pw}"}`))
+	defer e.Close()
+
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(buf.String(), "Registering Hook"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed registration was not logged")
+		}
+	}
+	if logged := buf.String(); !strings.Contains(logged, "Registering Hook with plugin upper failed -> failed ******") {
+		t.Errorf("log = %q, want the failure with the secret masked", logged)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	defer func(d time.Duration) { HandshakeTimeout = d }(HandshakeTimeout)
+	HandshakeTimeout = 100 * time.Millisecond
+	tests := []struct {
+		mode, err string
+	}{
+		{"version", fmt.Sprintf("plugin speaks protocol version %d, expecting %d", Version+1, Version)},
+		{"silent", "no handshake within 100ms"},
+	}
+	for _, tt := range tests {
+		p, err := start(t, tt.mode)
+		if err == nil {
+			p.Close()
+			t.Errorf("%s: Start succeeded", tt.mode)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want %s", tt.mode, err, tt.err)
+		}
+	}
+}
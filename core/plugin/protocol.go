@@ -0,0 +1,93 @@
+// Package plugin runs providers as separate executables. A plugin reads
+// requests from its stdin and writes responses to its stdout, one JSON
+// Message per line, and logs to its stderr.
+//
+// The runner opens with a handshake naming the protocol version, the plugin
+// answers with its own version and the provider it implements:
+//
+//	> {"type":"handshake","version":1}
+//	< {"type":"handshake","version":1,"info":{"name":"http","version":"1.0.0","schema":{...},"events":false}}
+//
+// Every run of a task is an execute request carrying the interpolated
+// properties, answered by a result with the outputs of the task, stored by
+// the runner as <Title>.<Output>, or an error. A cancel withdraws a request
+// still in progress:
+//
+//	> {"type":"execute","id":3,"task":"Fetch","properties":{"URL":"http://example.com"}}
+//	< {"type":"result","id":3,"outputs":{"Status":"200"}}
+//
+// Plugins whose info sets events start jobs: a register request is answered
+// by a result once the plugin is listening, after which every event with the
+// same id runs the job with its outputs. An unregister ends it.
+//
+//	> {"type":"register","id":4,"task":"Hook","properties":{...}}
+//	< {"type":"result","id":4}
+//	< {"type":"event","id":4,"outputs":{"Body":"..."}}
+//	> {"type":"unregister","id":4}
+//
+// Plugins listing filters in their info transform the values of $(...)
+// expressions, as in $(Fetch.Body | rot13). A filter request carries the text
+// of the value and the arguments of the filter, the result its new text:
+//
+//	> {"type":"filter","id":5,"filter":"rot13","value":"abc"}
+//	< {"type":"result","id":5,"value":"nop"}
+//
+// Requests and responses of different ids may be interleaved. Closing stdin
+// asks the plugin to exit.
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// Version is the version of the protocol, plugins speaking another version
+// are rejected at the handshake.
+const Version = 1
+
+// Message types.
+const (
+	TypeHandshake  = "handshake"
+	TypeExecute    = "execute"
+	TypeCancel     = "cancel"
+	TypeRegister   = "register"
+	TypeUnregister = "unregister"
+	TypeResult     = "result"
+	TypeEvent      = "event"
+	TypeFilter     = "filter"
+)
+
+// Info describes the provider implemented by a plugin.
+type Info struct {
+	Name    string      `json:"name"`
+	Aliases []string    `json:"aliases,omitempty"`
+	Version string      `json:"version,omitempty"`
+	Schema  core.Schema `json:"schema"`
+	// Events is set by plugins that start jobs, see TypeRegister
+	Events bool `json:"events,omitempty"`
+	// Filters are the expression filters of the plugin, see TypeFilter
+	Filters []string `json:"filters,omitempty"`
+}
+
+// Message is a line of the protocol, the fields used depend on Type.
+type Message struct {
+	Type string `json:"type"`
+	// ID ties responses and events to their request
+	ID      int64 `json:"id,omitempty"`
+	Version int   `json:"version,omitempty"`
+	Info    *Info `json:"info,omitempty"`
+
+	Task       string            `json:"task,omitempty"`
+	Properties json.RawMessage   `json:"properties,omitempty"`
+	Outputs    map[string]string `json:"outputs,omitempty"`
+
+	Filter string   `json:"filter,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Args   []string `json:"args,omitempty"`
+
+	// Error fails the request, Class is the error class matched by retry
+	// policies, see runner.ClassifyError
+	Error string `json:"error,omitempty"`
+	Class string `json:"class,omitempty"`
+}
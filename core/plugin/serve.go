@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Request is an execute or register request received by a plugin.
+type Request struct {
+	Task       string
+	Properties json.RawMessage
+}
+
+// Decode unmarshals the properties of the task into v.
+func (r *Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Properties, v)
+}
+
+// Handler implements the provider of a plugin. Execute runs one task and
+// returns its outputs, it should return once ctx is done. Errors with a
+// Class method, such as those of runner.ClassifyError, keep their class.
+type Handler interface {
+	Execute(ctx context.Context, req *Request) (outputs map[string]string, err error)
+}
+
+// EventHandler is implemented by handlers that start jobs. Register checks
+// req and returns at once, then calls emit for every event until ctx is done.
+type EventHandler interface {
+	Handler
+	Register(ctx context.Context, req *Request, emit func(outputs map[string]string)) error
+}
+
+// FilterHandler is implemented by handlers of plugins listing filters in
+// their Info, Filter returns value transformed by the filter called name.
+type FilterHandler interface {
+	Handler
+	Filter(name, value string, args []string) (string, error)
+}
+
+// Serve runs the plugin protocol for h on stdin and stdout until stdin is
+// closed. Plugins must log to stderr, stdout belongs to the protocol.
+func Serve(info Info, h Handler) error {
+	return ServeConn(os.Stdin, os.Stdout, info, h)
+}
+
+// ServeConn runs the plugin protocol for h, reading requests from r and
+// writing responses to w, until r is exhausted.
+func ServeConn(r io.Reader, w io.Writer, info Info, h Handler) error {
+	s := &server{
+		h:       h,
+		enc:     json.NewEncoder(w),
+		cancels: make(map[int64]context.CancelFunc),
+	}
+	events, ok := h.(EventHandler)
+	info.Events = ok
+
+	dec := json.NewDecoder(r)
+	var m Message
+	if err := dec.Decode(&m); err != nil {
+		return fmt.Errorf("reading handshake: %v", err)
+	}
+	if m.Type != TypeHandshake {
+		return fmt.Errorf("expecting a handshake, got %s", m.Type)
+	}
+	// the runner rejects other versions, answer anyway so it can say why
+	if err := s.send(&Message{Type: TypeHandshake, Version: Version, Info: &info}); err != nil {
+		return err
+	}
+
+	defer s.wg.Wait()
+	defer s.cancelAll()
+	for {
+		m := new(Message)
+		if err := dec.Decode(m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch m.Type {
+		case TypeExecute:
+			ctx := s.start(m.ID)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.cancel(m.ID)
+				outputs, err := h.Execute(ctx, &Request{Task: m.Task, Properties: m.Properties})
+				s.send(result(m.ID, outputs, err))
+			}()
+		case TypeRegister:
+			if !ok {
+				s.send(result(m.ID, nil, fmt.Errorf("%s does not start jobs", info.Name)))
+				continue
+			}
+			ctx := s.start(m.ID)
+			id := m.ID
+			err := events.Register(ctx, &Request{Task: m.Task, Properties: m.Properties}, func(outputs map[string]string) {
+				s.send(&Message{Type: TypeEvent, ID: id, Outputs: outputs})
+			})
+			if err != nil {
+				s.cancel(id)
+			}
+			s.send(result(id, nil, err))
+		case TypeFilter:
+			fh, ok := h.(FilterHandler)
+			if !ok {
+				s.send(result(m.ID, nil, fmt.Errorf("%s has no filters", info.Name)))
+				continue
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				value, err := fh.Filter(m.Filter, m.Value, m.Args)
+				res := result(m.ID, nil, err)
+				res.Value = value
+				s.send(res)
+			}()
+		case TypeCancel, TypeUnregister:
+			s.cancel(m.ID)
+		}
+	}
+}
+
+type server struct {
+	h     Handler
+	muEnc sync.Mutex
+	enc   *json.Encoder
+
+	// wg counts the execute and filter requests in progress
+	wg       sync.WaitGroup
+	muCancel sync.Mutex
+	cancels  map[int64]context.CancelFunc
+}
+
+// start returns the context of request id, done when it is cancelled.
+func (s *server) start(id int64) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.muCancel.Lock()
+	s.cancels[id] = cancel
+	s.muCancel.Unlock()
+	return ctx
+}
+
+func (s *server) cancel(id int64) {
+	s.muCancel.Lock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+	s.muCancel.Unlock()
+}
+
+func (s *server) cancelAll() {
+	s.muCancel.Lock()
+	for id, cancel := range s.cancels {
+		cancel()
+		delete(s.cancels, id)
+	}
+	s.muCancel.Unlock()
+}
+
+func (s *server) send(m *Message) error {
+	s.muEnc.Lock()
+	defer s.muEnc.Unlock()
+	return s.enc.Encode(m)
+}
+
+func result(id int64, outputs map[string]string, err error) *Message {
+	m := &Message{Type: TypeResult, ID: id, Outputs: outputs}
+	if err != nil {
+		m.Error = err.Error()
+		if c, ok := err.(interface {
+			Class() string
+		}); ok {
+			m.Class = c.Class()
+		}
+	}
+	return m
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff strategies of a RetryPolicy.
+const (
+	BackoffFixed       = "fixed"
+	BackoffExponential = "exponential"
+)
+
+// RetryPolicy is the retry option of a task:
+//
+//	retry:{
+//		attempts: 5
+//		backoff: exponential
+//		delay: 1s
+//		max_delay: 30s
+//		jitter: 0.2
+//		on:[
+//			timeout
+//		]
+//	}
+//
+// Attempts counts the first run, On lists the error classes that are retried
+// and retries every class when empty.
+type RetryPolicy struct {
+	Attempts Int      `json:"attempts"`
+	Backoff  String   `json:"backoff"`
+	Delay    Duration `json:"delay"`
+	MaxDelay Duration `json:"max_delay"`
+	// Jitter randomises each delay by up to this fraction of it
+	Jitter float64  `json:"jitter"`
+	On     []String `json:"on"`
+}
+
+// Check reports settings the runner could not honour.
+func (p *RetryPolicy) Check() error {
+	switch p.Backoff {
+	case "", BackoffFixed, BackoffExponential:
+	default:
+		return fmt.Errorf("invalid retry backoff %q, expecting %s or %s", p.Backoff, BackoffFixed, BackoffExponential)
+	}
+	if p.Attempts < 0 {
+		return fmt.Errorf("retry attempts must not be negative")
+	}
+	if p.Delay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("retry delays must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// MaxAttempts returns the number of times a task is run at most, 3 unless
+// set.
+func (p *RetryPolicy) MaxAttempts() int {
+	if p.Attempts == 0 {
+		return 3
+	}
+	return int(p.Attempts)
+}
+
+// Retries reports whether errors of class are retried.
+func (p *RetryPolicy) Retries(class string) bool {
+	if len(p.On) == 0 {
+		return true
+	}
+	for _, c := range p.On {
+		if string(c) == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait returns the delay before the next attempt after attempt failed. The
+// delay is 1s unless set, exponential backoff doubles it after every attempt.
+func (p *RetryPolicy) Wait(attempt int) time.Duration {
+	d := time.Duration(p.Delay)
+	if d == 0 {
+		d = time.Second
+	}
+	if p.Backoff == BackoffExponential {
+		for i := 1; i < attempt && d < math.MaxInt64/2 && (p.MaxDelay == 0 || d < time.Duration(p.MaxDelay)); i++ {
+			d *= 2
+		}
+	}
+	if p.MaxDelay > 0 && d > time.Duration(p.MaxDelay) {
+		d = time.Duration(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
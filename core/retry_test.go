@@ -0,0 +1,71 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyCheck(t *testing.T) {
+	tests := []struct {
+		policy RetryPolicy
+		err    string
+	}{
+		{RetryPolicy{}, ""},
+		{RetryPolicy{Attempts: 5, Backoff: BackoffExponential, Delay: Duration(time.Second), MaxDelay: Duration(time.Minute), Jitter: 1}, ""},
+		{RetryPolicy{Backoff: "linear"}, `invalid retry backoff "linear", expecting fixed or exponential`},
+		{RetryPolicy{Attempts: -1}, "retry attempts must not be negative"},
+		{RetryPolicy{Delay: -1}, "retry delays must not be negative"},
+		{RetryPolicy{MaxDelay: -1}, "retry delays must not be negative"},
+		{RetryPolicy{Jitter: 1.5}, "retry jitter must be between 0 and 1"},
+	}
+	for _, tt := range tests {
+		err := tt.policy.Check()
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("Check(%+v) = %v, want %q", tt.policy, err, tt.err)
+		}
+	}
+}
+
+func TestRetryPolicyRetries(t *testing.T) {
+	p := &RetryPolicy{}
+	if !p.Retries("timeout") || !p.Retries("error") || p.MaxAttempts() != 3 {
+		t.Errorf("the default policy retries timeout %t, error %t, %d attempts", p.Retries("timeout"), p.Retries("error"), p.MaxAttempts())
+	}
+	p = &RetryPolicy{Attempts: 1, On: []String{"timeout"}}
+	if !p.Retries("timeout") || p.Retries("error") || p.MaxAttempts() != 1 {
+		t.Errorf("%+v retries timeout %t, error %t, %d attempts", p, p.Retries("timeout"), p.Retries("error"), p.MaxAttempts())
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	s := Duration(time.Second)
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{"default", RetryPolicy{}, []time.Duration{time.Second, time.Second, time.Second}},
+		{"fixed", RetryPolicy{Delay: 2 * s}, []time.Duration{2 * time.Second, 2 * time.Second}},
+		{"exponential", RetryPolicy{Backoff: BackoffExponential, Delay: s}, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}},
+		{"capped", RetryPolicy{Backoff: BackoffExponential, Delay: s, MaxDelay: 5 * s}, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}},
+		{"delay above the cap", RetryPolicy{Delay: 10 * s, MaxDelay: 5 * s}, []time.Duration{5 * time.Second}},
+	}
+	for _, tt := range tests {
+		for i, want := range tt.want {
+			if got := tt.policy.Wait(i + 1); got != want {
+				t.Errorf("%s: Wait(%d) = %s, want %s", tt.name, i+1, got, want)
+			}
+		}
+	}
+	// a long run of attempts does not overflow
+	p := RetryPolicy{Backoff: BackoffExponential}
+	if d := p.Wait(100); d <= 0 {
+		t.Errorf("Wait(100) = %s", d)
+	}
+	p = RetryPolicy{Delay: 10 * s, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		if d := p.Wait(1); d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("Wait with 20%% jitter = %s, want 8s to 12s", d)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"context"
+)
+
+// Error classes matched by the on list of a retry policy. Providers may add
+// their own with ClassifyError.
+const (
+	ClassError   = "error"
+	ClassTimeout = "timeout"
+)
+
+type classError struct {
+	class string
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Class() string {
+	return e.class
+}
+
+// ClassifyError returns err tagged with class, see ErrorClass.
+func ClassifyError(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{class: class, err: err}
+}
+
+// ErrorClass returns the class err was tagged with by ClassifyError,
+// ClassTimeout for exceeded deadlines and ClassError for anything else.
+func ErrorClass(err error) string {
+	if c, ok := err.(interface {
+		Class() string
+	}); ok {
+		return c.Class()
+	}
+	if err == context.DeadlineExceeded {
+		return ClassTimeout
+	}
+	return ClassError
+}
@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// Filter transforms the value of a $(...) expression, as in
+// $(Task.Stdout | trim). args are the arguments written after its name.
+type Filter func(v Value, args []string) (Value, error)
+
+// DefaultFilter replaces missing and empty values, the filters before it are
+// skipped while the output is missing.
+const DefaultFilter = "default"
+
+var (
+	muFilters sync.RWMutex
+	filters   = map[string]Filter{
+		"trim":       textFilter(strings.TrimSpace),
+		"upper":      textFilter(strings.ToUpper),
+		"lower":      textFilter(strings.ToLower),
+		"urlencode":  textFilter(url.QueryEscape),
+		"shellquote": textFilter(shellQuote),
+		"base64": func(v Value, args []string) (Value, error) {
+			if err := arguments(args, 0); err != nil {
+				return Value{}, err
+			}
+			return StringValue(base64.StdEncoding.EncodeToString([]byte(v.String()))), nil
+		},
+		"json": func(v Value, args []string) (Value, error) {
+			if err := arguments(args, 0); err != nil {
+				return Value{}, err
+			}
+			return JSONValue(v.JSON()), nil
+		},
+		"len":         lenFilter,
+		DefaultFilter: defaultFilter,
+	}
+)
+
+// RegisterFilter adds the filter name for the expressions of every job,
+// failing when the name is taken. Providers register theirs when they are
+// created, plugins when they are started.
+func RegisterFilter(name string, f Filter) error {
+	if len(name) == 0 || strings.ContainsAny(name, " \t|\"'()") {
+		return fmt.Errorf("invalid filter name %q", name)
+	}
+	muFilters.Lock()
+	defer muFilters.Unlock()
+	if _, ok := filters[name]; ok {
+		return fmt.Errorf("filter %s is already registered", name)
+	}
+	filters[name] = f
+	return nil
+}
+
+// LookupFilter returns the filter registered as name.
+func LookupFilter(name string) (f Filter, ok bool) {
+	muFilters.RLock()
+	f, ok = filters[name]
+	muFilters.RUnlock()
+	return
+}
+
+// Filters lists the names of the registered filters.
+func Filters() (names []string) {
+	muFilters.RLock()
+	for name := range filters {
+		names = append(names, name)
+	}
+	muFilters.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+func arguments(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("takes %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+// textFilter is a filter applying fn to the text of the value.
+func textFilter(fn func(string) string) Filter {
+	return func(v Value, args []string) (Value, error) {
+		if err := arguments(args, 0); err != nil {
+			return Value{}, err
+		}
+		return StringValue(fn(v.String())), nil
+	}
+}
+
+func defaultFilter(v Value, args []string) (Value, error) {
+	if err := arguments(args, 1); err != nil {
+		return Value{}, err
+	}
+	if v.Interface() == nil || len(v.String()) == 0 {
+		return StringValue(args[0]), nil
+	}
+	return v, nil
+}
+
+// lenFilter counts the items of JSON arrays, the fields of JSON objects and
+// the characters of text.
+func lenFilter(v Value, args []string) (Value, error) {
+	if err := arguments(args, 0); err != nil {
+		return Value{}, err
+	}
+	switch v.Kind {
+	case KindBytes:
+		b, _ := v.Interface().([]byte)
+		return NumberValue(float64(len(b))), nil
+	case KindJSON:
+		doc, err := core.ParseDocument(v.JSON())
+		if err != nil {
+			return Value{}, err
+		}
+		switch x := doc.(type) {
+		case []interface{}:
+			return NumberValue(float64(len(x))), nil
+		case map[string]interface{}:
+			return NumberValue(float64(len(x))), nil
+		case string:
+			return NumberValue(float64(utf8.RuneCountInString(x))), nil
+		case nil:
+			return NumberValue(0), nil
+		}
+		return Value{}, fmt.Errorf("cannot count %s", string(v.JSON()))
+	case KindString:
+		return NumberValue(float64(utf8.RuneCountInString(v.String()))), nil
+	}
+	return Value{}, fmt.Errorf("cannot count %s", kindName(v.Kind))
+}
+
+// shellQuote quotes s as a single word for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	j := newTestJob(nil)
+	j.Store("Echo.Stdout", "  It's done\n")
+	j.Store("Echo.Empty", "")
+	j.Store("Nodes.Result", json.RawMessage(`[{"name":"a"},{"name":"é"}]`))
+	j.Store("Nodes.Doc", json.RawMessage(`{"a":1,"b":2,"c":3}`))
+	j.Store("Read.Bytes", []byte("abc"))
+	j.Store("Count.N", 3)
+
+	tests := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		{expr: "Echo.Stdout | trim", want: "It's done"},
+		{expr: "Echo.Stdout | trim | upper", want: "IT'S DONE"},
+		{expr: "Echo.Stdout | trim | lower", want: "it's done"},
+		{expr: "Echo.Stdout | trim | urlencode", want: "It%27s+done"},
+		{expr: "Echo.Stdout | trim | shellquote", want: `'It'\''s done'`},
+		{expr: "Echo.Stdout | trim | base64", want: "SXQncyBkb25l"},
+		{expr: "Echo.Stdout | json", want: `"  It's done\n"`},
+		{expr: "Nodes.Result | json", want: `[{"name":"a"},{"name":"é"}]`},
+		{expr: "Nodes.Result | len", want: "2"},
+		{expr: "Nodes.Doc | len", want: "3"},
+		{expr: "Nodes.Result[1].name | len", want: "1"},
+		{expr: "Read.Bytes | len", want: "3"},
+		{expr: "Echo.Stdout | trim | len", want: "9"},
+		{expr: "Echo.Empty | default none", want: "none"},
+		{expr: "Echo.Stdout | trim | default none", want: "It's done"},
+		{expr: `Missing.Stdout | trim | upper | default "not run"`, want: "not run"},
+		{expr: "Count.N | len", err: "filter len: cannot count"},
+		{expr: "Echo.Stdout | trim extra", err: "filter trim: takes 0 arguments, got 1"},
+		{expr: "Echo.Stdout | default", err: "filter default: takes 1 arguments, got 0"},
+		{expr: "Echo.Stdout | rot13", err: "unknown filter rot13"},
+	}
+	for _, tt := range tests {
+		v, ok, err := j.evalExpression(tt.expr)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("$(%s) = %q, %v, want an error containing %q", tt.expr, v.String(), err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !ok || v.String() != tt.want {
+			t.Errorf("$(%s) = %q, %t, %v, want %q", tt.expr, v.String(), ok, err, tt.want)
+		}
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	reverse := func(v Value, args []string) (Value, error) {
+		r := []rune(v.String())
+		for i, k := 0, len(r)-1; i < k; i, k = i+1, k-1 {
+			r[i], r[k] = r[k], r[i]
+		}
+		return StringValue(string(r)), nil
+	}
+	if err := RegisterFilter("test_reverse", reverse); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		muFilters.Lock()
+		delete(filters, "test_reverse")
+		muFilters.Unlock()
+	}()
+	for _, name := range []string{"test_reverse", "trim", "", "a b", "a|b", `a"b`} {
+		if err := RegisterFilter(name, reverse); err == nil {
+			t.Errorf("RegisterFilter(%q) succeeded", name)
+		}
+	}
+	if _, ok := LookupFilter("test_reverse"); !ok {
+		t.Error("LookupFilter did not find test_reverse")
+	}
+	found := false
+	for _, name := range Filters() {
+		found = found || name == "test_reverse"
+	}
+	if !found {
+		t.Errorf("Filters = %q, want test_reverse among them", Filters())
+	}
+
+	j := newTestJob(nil)
+	j.Store("Echo.Stdout", "abc")
+	got, err := j.InterpolateState(`{"Out": "$(Echo.Stdout | test_reverse | upper)"}`)
+	if err != nil || string(got) != `{"Out": "CBA"}` {
+		t.Errorf("InterpolateState = %s, %v", got, err)
+	}
+}
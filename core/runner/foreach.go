@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// runForEach runs t once for every item of its foreach option, at most
+// Concurrency items at a time. Every iteration runs in a job of its own with
+// a copy of the state holding $(item) and $(index), its
+// when condition and retries apply to each item. Once all have finished
+// every <Title>.<Output> is stored as a JSON array of the values of the
+// iterations, null for skipped ones. The first failing item cancels the
+// others.
+func (j *Job) runForEach(ctx context.Context, t Task) error {
+	var lookupErr error
+	items, err := t.Options.ForEach.Items(func(ref string) (interface{}, bool) {
+		v, ok, err := j.evalExpression(ref)
+		lookupErr = err
+		return v.String(), ok
+	})
+	if lookupErr != nil {
+		err = lookupErr
+	}
+	if err != nil {
+		j.record(TaskRun{Task: t.Title, Start: time.Now(), Err: err})
+		return err
+	}
+	n := int(t.Options.Concurrency)
+	if n < 1 {
+		n = 1
+	}
+	log.Printf("Running task %s of job %d over %d items, %d at a time\n", t.Title, j.ID, len(items), n)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	iterations := make([]*Job, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		it := j.iteration(t, i, item)
+		iterations[i] = it
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = it.runTask(ctx, it.Tasks[0]); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	j.collect(t.Title, iterations)
+	for i, err := range errs {
+		if err != nil {
+			return ClassifyError(ErrorClass(err), fmt.Errorf("item %d: %v", i, err))
+		}
+	}
+	return ctx.Err()
+}
+
+// iteration returns the job running item i of the foreach task t.
+func (j *Job) iteration(t Task, i int, item interface{}) *Job {
+	t.Options.ForEach = ""
+	t.Options.Concurrency = 0
+
+	// outputs of the task itself are left out, everything the iteration
+	// stores under its title is collected afterwards
+	prefix := t.Title + "."
+	it := &Job{
+		ID: j.ID,
+		State: j.State.Copy(func(key string) bool {
+			return !strings.HasPrefix(key, prefix)
+		}),
+		Tasks:   []Task{t},
+		ctx:     j.ctx,
+		secrets: j.secrets,
+		jobLog:  new(jobLog),
+	}
+	it.Store(core.ForEachIndex, i)
+	storeItem(it, core.ForEachItem, item)
+	return it
+}
+
+// storeItem stores v under key, and the fields of objects under
+// key.<field>. Objects and arrays are stored as JSON documents.
+func storeItem(j *Job, key string, v interface{}) {
+	switch v.(type) {
+	case string, json.Number, bool:
+		j.Store(key, v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			b = json.RawMessage("null")
+		}
+		j.Store(key, json.RawMessage(b))
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, fv := range m {
+			storeItem(j, key+"."+k, fv)
+		}
+	}
+}
+
+// collect adds the history and secrets of the iterations of the foreach task
+// title to j and stores the outputs of the task as arrays.
+func (j *Job) collect(title string, iterations []*Job) {
+	outputs := make(map[string][]json.RawMessage)
+	for i, it := range iterations {
+		if it == nil {
+			continue
+		}
+		for _, run := range it.History() {
+			run.Task = fmt.Sprintf("%s[%d]", title, i)
+			j.record(run)
+		}
+		it.muSecret.Lock()
+		resolved := it.resolved
+		it.muSecret.Unlock()
+		j.muSecret.Lock()
+		j.resolved = append(j.resolved, resolved...)
+		j.muSecret.Unlock()
+
+		for name, v := range it.State.Outputs(title) {
+			if _, ok := outputs[name]; !ok {
+				outputs[name] = make([]json.RawMessage, len(iterations))
+			}
+			outputs[name][i] = jsonValue(v)
+		}
+	}
+	for name, values := range outputs {
+		for i, v := range values {
+			if v == nil {
+				values[i] = json.RawMessage("null")
+			}
+		}
+		b, _ := json.Marshal(values)
+		v := JSONValue(b)
+		v.Task = title
+		j.State.Set(title+"."+name, v)
+	}
+}
+
+// jsonValue embeds v in an aggregated output. JSON documents such as a mongo
+// Result are embedded as they are, and so are strings holding a JSON object
+// or array, as plugin outputs do.
+func jsonValue(v Value) json.RawMessage {
+	if v.Kind == KindString {
+		t := strings.TrimSpace(v.String())
+		if (strings.HasPrefix(t, "[") || strings.HasPrefix(t, "{")) && json.Valid([]byte(t)) {
+			return json.RawMessage(t)
+		}
+	}
+	return v.JSON()
+}
@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// itemProvider publishes its Name property as Out, failing when Fail is
+// set. It counts how many of its tasks run at the same time.
+type itemProvider struct {
+	mu            sync.Mutex
+	running, peak int
+}
+
+func (p *itemProvider) String() string {
+	return "item"
+}
+
+func (p *itemProvider) Execute(j *Job) error {
+	return nil
+}
+
+func (p *itemProvider) ExecuteContext(ctx context.Context, j *Job, t *Task) error {
+	var props struct {
+		Name  string
+		Fail  bool
+		Sleep core.Duration
+	}
+	if err := j.Decode(t, &props); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.running++; p.running > p.peak {
+		p.peak = p.running
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.running--
+		p.mu.Unlock()
+	}()
+	select {
+	case <-time.After(time.Duration(props.Sleep)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if props.Fail {
+		return errors.New("failed " + props.Name)
+	}
+	j.Publish(t, "Out", props.Name)
+	return nil
+}
+
+func TestForEach(t *testing.T) {
+	tests := []struct {
+		name        string
+		props       string
+		foreach     core.ForEach
+		when        core.String
+		concurrency int
+		out         string
+		history     []string
+		peak        int
+		err         string
+	}{
+		{
+			name:    "literal items",
+			props:   `{"Name": "$(index):$(item)"}`,
+			foreach: `["a","b","c"]`,
+			out:     `["0:a","1:b","2:c"]`,
+			history: []string{"Each[0]", "Each[1]", "Each[2]"},
+			peak:    1,
+		},
+		{
+			name:        "fields of a result",
+			props:       `{"Name": "$(item.name)", "Sleep": "20ms"}`,
+			foreach:     `$(Latest.Result)`,
+			concurrency: 2,
+			out:         `["a","b","c","d"]`,
+			history:     []string{"Each[0]", "Each[1]", "Each[2]", "Each[3]"},
+			peak:        2,
+		},
+		{
+			name:    "skipped items",
+			props:   `{"Name": "$(item.name)"}`,
+			foreach: `$(Latest.Result)`,
+			when:    `$(item.name) != b`,
+			out:     `["a",null,"c","d"]`,
+			history: []string{"Each[0]", "Each[1]", "Each[2]", "Each[3]"},
+			peak:    1,
+		},
+		{
+			name:    "no items",
+			props:   `{"Name": "$(item)"}`,
+			foreach: `[]`,
+			peak:    0,
+		},
+		{
+			name:        "failing item",
+			props:       `{"Name": "$(item.name)", "Fail": $(item.fail), "Sleep": "$(item.sleep)"}`,
+			foreach:     `[{"name":"a","fail":false,"sleep":"1h"},{"name":"b","fail":true,"sleep":"50ms"}]`,
+			concurrency: 2,
+			history:     []string{"Each[0]", "Each[1]"},
+			peak:        2,
+			err:         "item 0: context canceled",
+		},
+		{
+			name:    "not an array",
+			props:   `{}`,
+			foreach: `$(In.Method)`,
+			history: []string{"Each"},
+			err:     "foreach $(In.Method) is not a JSON array",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := new(itemProvider)
+			j := newTestJob(nil, Task{Title: "Each", Properties: json.RawMessage(tt.props), Provider: p, Options: core.TaskOptions{
+				ForEach:     tt.foreach,
+				When:        tt.when,
+				Concurrency: core.Int(tt.concurrency),
+			}})
+			j.Store("In.Method", "POST")
+			j.Store("Latest.Result", json.RawMessage(`[{"name":"a"},{"name":"b"},{"name":"c"},{"name":"d"}]`))
+			err := j.runTask(context.Background(), j.Tasks[0])
+			if len(tt.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if out, _ := j.Load("Each.Out"); len(tt.out) > 0 && fmt.Sprintf("%s", out) != tt.out {
+				t.Errorf("Each.Out = %s, want %s", out, tt.out)
+			}
+			var history []string
+			for _, run := range j.History() {
+				history = append(history, run.Task)
+			}
+			if strings.Join(history, ",") != strings.Join(tt.history, ",") {
+				t.Errorf("history = %q, want %q", history, tt.history)
+			}
+			if p.peak != tt.peak {
+				t.Errorf("%d items ran at the same time, want %d", p.peak, tt.peak)
+			}
+			// iterations do not leak their item into the job
+			if _, ok := j.Load(core.ForEachItem); ok {
+				t.Error("$(item) is set after the foreach task")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// stepProvider records the Step property of the tasks it runs in the order
+// they finish.
+type stepProvider struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (p *stepProvider) String() string {
+	return "step"
+}
+
+func (p *stepProvider) Execute(j *Job) error {
+	return nil
+}
+
+func (p *stepProvider) ExecuteContext(ctx context.Context, j *Job, t *Task) error {
+	var props struct {
+		Step  string
+		Sleep core.Duration
+	}
+	if err := json.Unmarshal(t.Properties, &props); err != nil {
+		return err
+	}
+	time.Sleep(time.Duration(props.Sleep))
+	p.mu.Lock()
+	p.steps = append(p.steps, props.Step)
+	p.mu.Unlock()
+	return nil
+}
+
+func TestRunGraph(t *testing.T) {
+	task := func(p Provider, title, props string, after ...int) Task {
+		return Task{Title: title, Properties: json.RawMessage(props), Provider: p, Options: core.TaskOptions{After: after}}
+	}
+	tests := []struct {
+		name  string
+		first int
+		tasks func(p Provider) []Task
+		want  []string
+	}{
+		{
+			name: "tasks sharing a title",
+			tasks: func(p Provider) []Task {
+				return []Task{
+					task(p, "In", `{"Step":"listen"}`),
+					task(p, "Merge", `{"Step":"merge","Sleep":"50ms"}`, 0),
+					task(p, "In", `{"Step":"respond"}`, 0, 1),
+				}
+			},
+			want: []string{"listen", "merge", "respond"},
+		},
+		{
+			name: "independent tasks",
+			tasks: func(p Provider) []Task {
+				return []Task{
+					task(p, "Slow", `{"Step":"slow","Sleep":"100ms"}`),
+					task(p, "Fast", `{"Step":"fast"}`),
+					task(p, "Both", `{"Step":"both"}`, 0, 1),
+				}
+			},
+			want: []string{"fast", "slow", "both"},
+		},
+		{
+			name:  "started at a later event",
+			first: 1,
+			tasks: func(p Provider) []Task {
+				return []Task{
+					task(p, "Other", `{"Step":"other"}`),
+					task(p, "In", `{"Step":"listen"}`),
+					task(p, "Merge", `{"Step":"merge","Sleep":"50ms"}`, 0, 1),
+					task(p, "In", `{"Step":"respond"}`, 1, 2),
+				}
+			},
+			want: []string{"listen", "merge", "respond"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := new(stepProvider)
+			spec := &JobSpec{Name: "test", Options: core.JobOptions{Parallel: true}, Tasks: tt.tasks(p)}
+			j := JobFactory(context.Background(), spec, nil)(tt.first)()
+			j.Run()
+			select {
+			case <-j.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job did not finish")
+			}
+			for _, run := range j.History() {
+				if run.Err != nil {
+					t.Errorf("task %s: %v", run.Task, run.Err)
+				}
+			}
+			if !reflect.DeepEqual(p.steps, tt.want) {
+				t.Errorf("steps = %v, want %v", p.steps, tt.want)
+			}
+		})
+	}
+}
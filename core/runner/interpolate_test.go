@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInterpolatePaths(t *testing.T) {
+	j := newTestJob(nil)
+	j.Store("Nodes.Result", json.RawMessage(`[{"hostname":"razor-1","cpus":4,"tags":["a","b"]}]`))
+	j.Store("Listen.Body", `{"user":{"id":7,"name":"ann \"a\""}}`)
+	j.Store("Fetch.Stdout", "  text  ")
+
+	tests := []struct {
+		data string
+		want string
+		err  string
+	}{
+		{data: `{"Host": "$(Nodes.Result[0].hostname)"}`, want: `{"Host": "razor-1"}`},
+		{data: `{"Cpus": "$(Nodes.Result[0].cpus)"}`, want: `{"Cpus": "4"}`},
+		{data: `{"Tags": "$(Nodes.Result[0].tags)"}`, want: `{"Tags": "[\"a\",\"b\"]"}`},
+		{data: `{"Id": "$(Listen.Body.json.user.id)"}`, want: `{"Id": "7"}`},
+		{data: `{"Name": "$(Listen.Body.json.user.name)"}`, want: `{"Name": "ann \"a\""}`},
+		{data: `{"Out": "$(Fetch.Stdout | trim)"}`, want: `{"Out": "text"}`},
+		{data: `{"Host": "$(Nodes.Result[1].hostname | default "none")"}`, want: `{"Host": "none"}`},
+		{data: `{"Out": "$(Missing.Stdout | trim | default "none")"}`, want: `{"Out": "none"}`},
+		{data: `{"Host": "$(Nodes.Result[1].hostname)"}`, err: "Nodes.Result[1] is out of range"},
+		{data: `{"Id": "$(Listen.Body.json.user.missing)"}`, err: "Listen.Body.json.user has no field missing"},
+		{data: `{"Id": "$(Listen.Body.user)"}`, err: "select from its JSON with $(Listen.Body.json.user)"},
+		{data: `{"Out": "$(Missing.Stdout)"}`, err: "$(Missing.Stdout): no task stored this output"},
+		{data: `{"Out": "$(Missing.Stdout | trim)"}`, err: "$(Missing.Stdout | trim): no task stored this output"},
+	}
+	for _, tt := range tests {
+		got, err := j.InterpolateState(tt.data)
+		if len(tt.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("InterpolateState(%s) = %s, %v, want an error containing %q", tt.data, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("InterpolateState(%s) = %s, %v, want %s", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalConditionMissing(t *testing.T) {
+	j := newTestJob(nil)
+	j.Store("Nodes.Result", json.RawMessage(`[]`))
+	tests := []struct {
+		cond string
+		want bool
+		err  bool
+	}{
+		{cond: "$(Missing.Stdout)", want: false},
+		{cond: "!$(Missing.Stdout)", want: true},
+		{cond: "$(Nodes.Result)", want: false},
+		{cond: "$(Nodes.Result[0].hostname)", err: true},
+		{cond: `$(Nodes.Result[0].hostname | default "x") == x`, want: true},
+	}
+	for _, tt := range tests {
+		got, err := j.evalCondition(tt.cond)
+		if (err != nil) != tt.err || (!tt.err && got != tt.want) {
+			t.Errorf("evalCondition(%s) = %v, %v, want %v, error %v", tt.cond, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -2,25 +2,90 @@ package runner
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
+
+	"github.com/Kozical/taskengine/core"
 )
 
+// Provider runs the tasks of one resource of a job. A provider may be shared
+// by several tasks and by every run of the job, runs may overlap, so anything
+// that differs between tasks or runs belongs in the Job and not in the
+// provider. Execute finds the task it runs with Job.Task.
 type Provider interface {
 	Execute(*Job) error
 }
 
+// ContextProvider is implemented by providers that can be cancelled, the
+// task being run is passed along so they need no state of their own. Tasks
+// of other providers are abandoned when their timeout expires or the job is
+// withdrawn, the provider keeps running in the background.
+type ContextProvider interface {
+	ExecuteContext(ctx context.Context, j *Job, t *Task) error
+}
+
+// EventProvider starts jobs in response to events, every call of fn returns
+// a fresh job for one run. Register runs until ctx is cancelled, when the
+// job is withdrawn, and must release everything it registered before
+// returning.
 type EventProvider interface {
-	Register(func() *Job)
+	Register(ctx context.Context, t *Task, fn func() *Job)
 }
 
 type Task struct {
 	Title      string
 	Properties json.RawMessage
 	Provider   Provider
+	Options    core.TaskOptions
+}
+
+// Execute runs the task in j, cancelling it after its timeout. The provider
+// is handed a view of j whose Task method returns t.
+func (t Task) Execute(ctx context.Context, j *Job) error {
+	if t.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t.Options.Timeout))
+		defer cancel()
+	}
+	j = j.forTask(&t)
+	if p, ok := t.Provider.(ContextProvider); ok {
+		err := p.ExecuteContext(ctx, j, &t)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return ClassifyError(ClassTimeout, err)
+		}
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- t.Provider.Execute(j)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TaskRun records one attempt at running a task.
+type TaskRun struct {
+	Task     string
+	Attempt  int
+	Start    time.Time
+	Duration time.Duration
+	// Err is nil when the attempt succeeded
+	Err error
+	// Skipped is set when the task did not run because its when condition
+	// was false
+	Skipped bool
 }
 
 func (t Task) String() string {
@@ -28,39 +93,483 @@ func (t Task) String() string {
 }
 
 type Job struct {
-	ID    int
-	State map[string]func() interface{}
+	ID int
+	// State holds the outputs of the tasks that have run
+	State *State
 	Tasks []Task
+	// OnFailure runs when one of Tasks failed, Finally after every run
+	OnFailure []Task
+	Finally   []Task
+
+	// ctx is cancelled when the dispatched job is withdrawn or replaced
+	ctx      context.Context
+	timeout  time.Duration
+	parallel bool
+	// first is the index in the JobSpec of the first of Tasks, the After
+	// option of a task holds indices in the JobSpec
+	first int
+	done  chan struct{}
+	// task is the task a view of the job was handed to, see Task
+	task *Task
+
+	secrets SecretStore
+	*jobLog
+}
+
+// jobLog holds what a run records, shared by a job and the views of it handed
+// to its providers.
+type jobLog struct {
+	muHistory sync.Mutex
+	history   []TaskRun
+
+	muSecret sync.Mutex
+	// resolved holds the secret values interpolated into this job so they
+	// can be redacted from anything written to the log
+	resolved []string
 }
 
 func (j *Job) String() string {
-	return fmt.Sprintf("Job{ID: %d, State: %v, Tasks[%s]}\n", j.ID, j.State, j.Tasks)
+	return j.Redact(fmt.Sprintf("Job{ID: %d, State: %v, Tasks[%s]}\n", j.ID, j.State, j.Tasks))
 }
 
-func (j *Job) Store(key string, fn func() interface{}) {
-	j.State[key] = fn
+// Redact masks every secret value resolved for this job in s.
+func (j *Job) Redact(s string) string {
+	j.muSecret.Lock()
+	defer j.muSecret.Unlock()
+	for _, v := range j.resolved {
+		s = strings.Replace(s, v, "******", -1)
+	}
+	return s
 }
 
+// resolveReference returns the value of a ${scheme:name} reference, keeping
+// secrets for Redact.
+func (j *Job) resolveReference(scheme, name string) (value string, ok bool) {
+	value, err := lookupReference(scheme, name, j.secrets)
+	if err != nil {
+		log.Printf("Unresolved reference in job %d -> %v\n", j.ID, err)
+		return
+	}
+	if scheme == "secret" && len(value) > 0 {
+		j.muSecret.Lock()
+		j.resolved = append(j.resolved, value)
+		if escaped := core.JSONEscape(value); escaped != value {
+			j.resolved = append(j.resolved, escaped)
+		}
+		j.muSecret.Unlock()
+	}
+	return value, true
+}
+
+// Store stores v under key, converted with ValueOf.
+func (j *Job) Store(key string, v interface{}) {
+	j.State.Set(key, ValueOf(v))
+}
+
+// Load returns the Go value stored under key, ok is false when there is none.
+func (j *Job) Load(key string) (value interface{}, ok bool) {
+	v, ok := j.State.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// Decode interpolates the properties of t with the state of the job and
+// unmarshals them into settings, usually the Settings type of a provider.
+// Errors quote interpolated values, secrets are masked in them.
+func (j *Job) Decode(t *Task, settings interface{}) error {
+	if t == nil {
+		return fmt.Errorf("job %d has no task to decode", j.ID)
+	}
+	properties, err := j.InterpolateState(string(t.Properties))
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(properties, settings); err != nil {
+		return errors.New(j.Redact(err.Error()))
+	}
+	return nil
+}
+
+// Publish stores value, converted with ValueOf, as the output name of t
+// referenced by later tasks as $(<Title>.<name>).
+func (j *Job) Publish(t *Task, name string, value interface{}) {
+	v := ValueOf(value)
+	v.Task = t.Title
+	j.State.Set(t.Title+"."+name, v)
+}
+
+// History returns the task attempts of the latest run of the job.
+func (j *Job) History() []TaskRun {
+	j.muHistory.Lock()
+	defer j.muHistory.Unlock()
+	return append([]TaskRun(nil), j.history...)
+}
+
+func (j *Job) record(run TaskRun) {
+	j.muHistory.Lock()
+	j.history = append(j.history, run)
+	j.muHistory.Unlock()
+}
+
+// runTask executes t, running it again as long as its retry policy allows.
+// Every attempt is logged and recorded in the job's history, the number of
+// attempts is stored as <Title>.Attempts. A task whose when condition is
+// false is recorded as skipped and does not fail the job. Foreach tasks are
+// handed to runForEach.
+func (j *Job) runTask(ctx context.Context, t Task) (err error) {
+	if len(t.Options.ForEach) > 0 {
+		return j.runForEach(ctx, t)
+	}
+	if len(t.Options.When) > 0 {
+		var run bool
+		if run, err = j.evalCondition(t.Options.When.String()); err != nil || !run {
+			if err == nil {
+				log.Printf("Skipping task %s of job %d, %s is false\n", t.Title, j.ID, t.Options.When)
+			}
+			j.record(TaskRun{Task: t.Title, Start: time.Now(), Err: err, Skipped: err == nil})
+			return
+		}
+	}
+	retry := t.Options.Retry
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err = t.Execute(ctx, j)
+		j.record(TaskRun{
+			Task:     t.Title,
+			Attempt:  attempt,
+			Start:    start,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		j.Publish(&t, "Attempts", attempt)
+
+		if err == nil || retry == nil || ctx.Err() != nil {
+			return
+		}
+		class := ErrorClass(err)
+		if attempt >= retry.MaxAttempts() || !retry.Retries(class) {
+			return
+		}
+		wait := retry.Wait(attempt)
+		log.Printf("Attempt %d/%d of task %s in job %d failed (%s) -> %s, retrying in %s\n", attempt, retry.MaxAttempts(), t.Title, j.ID, class, j.Redact(err.Error()), wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// evalCondition evaluates a when condition against the state of the job.
+func (j *Job) evalCondition(text string) (bool, error) {
+	c, err := core.ParseCondition(text)
+	if err != nil {
+		return false, err
+	}
+	result := c.Eval(func(ref string) (string, bool) {
+		if strings.HasPrefix(ref, "${") {
+			scheme, name, n := core.ScanReference(ref)
+			if n < 0 {
+				return "", false
+			}
+			return j.resolveReference(scheme, name)
+		}
+		v, ok, lerr := j.evalExpression(ref[2 : len(ref)-1])
+		if lerr != nil && err == nil {
+			err = lerr
+		}
+		return v.String(), ok
+	})
+	return result, err
+}
+
+// evalExpression returns the value of the content of a $(...) expression:
+// the output it refers to piped through its filters. ok is false when the
+// output is missing and no default filter replaced it, a path that does not
+// resolve is an error unless a default filter replaced it.
+func (j *Job) evalExpression(text string) (v Value, ok bool, err error) {
+	e, err := core.ParseExpression(text)
+	if err != nil {
+		return Value{}, false, fmt.Errorf("$(%s): %v", text, err)
+	}
+	v, ok, pathErr := j.lookupValue(e.Ref)
+	for _, call := range e.Filters {
+		if !ok && call.Name != DefaultFilter {
+			continue
+		}
+		f, found := LookupFilter(call.Name)
+		if !found {
+			return Value{}, false, fmt.Errorf("$(%s): unknown filter %s", text, call.Name)
+		}
+		if v, err = f(v, call.Args); err != nil {
+			return Value{}, false, fmt.Errorf("$(%s): filter %s: %v", text, call.Name, err)
+		}
+		ok = true
+	}
+	if !ok && pathErr != nil {
+		return Value{}, false, fmt.Errorf("$(%s): %v", text, pathErr)
+	}
+	return
+}
+
+// lookupValue returns the value ref refers to: a key of the state,
+// optionally followed by a path into its value. ok is false when no key
+// matches, err is set when the path does not resolve.
+func (j *Job) lookupValue(ref string) (v Value, ok bool, err error) {
+	for _, key := range core.ReferenceKeys(ref) {
+		found, exists := j.State.Get(key)
+		if !exists {
+			continue
+		}
+		if len(key) == len(ref) {
+			return found, true, nil
+		}
+		if v, err = selectPath(key, found, ref[len(key):]); err != nil {
+			return Value{}, false, err
+		}
+		return v, true, nil
+	}
+	return Value{}, false, nil
+}
+
+// selectPath returns the value path selects in v, stored under key. JSON
+// documents take the path as it is, text has to be parsed first with a
+// leading .json segment.
+func selectPath(key string, v Value, text string) (Value, error) {
+	path, err := core.ParsePath(text)
+	if err != nil {
+		return Value{}, err
+	}
+	var doc []byte
+	switch v.Kind {
+	case KindJSON:
+		doc = v.JSON()
+	case KindString, KindBytes:
+		if len(path) == 0 || path[0].IsIndex || path[0].Field != core.ParseJSONSegment {
+			return Value{}, fmt.Errorf("%s is %s, select from its JSON with $(%s.%s%s)", key, kindName(v.Kind), key, core.ParseJSONSegment, text)
+		}
+		key += "." + core.ParseJSONSegment
+		path = path[1:]
+		doc = []byte(v.String())
+	default:
+		return Value{}, fmt.Errorf("%s is %s, it has no %s", key, kindName(v.Kind), text)
+	}
+	parsed, err := core.ParseDocument(doc)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s is not JSON: %v", key, err)
+	}
+	selected, err := path.Select(key, parsed)
+	if err != nil {
+		return Value{}, err
+	}
+	return selectedValue(selected), nil
+}
+
+// selectedValue returns a value selected by a path, text stays text and
+// everything else is a JSON document.
+func selectedValue(v interface{}) Value {
+	if s, ok := v.(string); ok {
+		return StringValue(s)
+	}
+	if n, ok := v.(json.Number); ok {
+		return JSONValue(json.RawMessage(n.String()))
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b = json.RawMessage("null")
+	}
+	return JSONValue(b)
+}
+
+// Context returns the context of the dispatched job, it is done once the job
+// has been withdrawn.
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Done returns a channel that is closed when the latest run of the job has
+// ended, its on_failure and finally tasks included.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Task returns the task the job was handed to a provider for, nil outside
+// of Task.Execute.
+func (j *Job) Task() *Task {
+	return j.task
+}
+
+// forTask returns a view of j for the provider of t, it shares everything but
+// its task with j.
+func (j *Job) forTask(t *Task) *Job {
+	v := *j
+	v.task = t
+	return &v
+}
+
+// Run executes the tasks of the job in the background, the run is cancelled
+// when the job's timeout expires or the job is withdrawn. When a task fails
+// the on_failure tasks run with the error stored as error.Message and
+// error.Task, the finally tasks run after every run. Both only stop early when
+// the job is withdrawn.
 func (j *Job) Run() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if j.timeout > 0 {
+		ctx, cancel = context.WithTimeout(j.ctx, j.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(j.ctx)
+	}
+	j.muHistory.Lock()
+	j.history = nil
+	j.muHistory.Unlock()
+	done := make(chan struct{})
+	j.done = done
 	go func() {
-		for _, t := range j.Tasks {
-			log.Printf("Running task %s (%s) of job %d\n", t.Title, t.Provider, j.ID)
-			err := t.Provider.Execute(j)
-			if err != nil {
-				fmt.Printf("Error while executing %s -> %v\n", t.Title, err)
+		defer close(done)
+		var failed string
+		var err error
+		if j.parallel {
+			failed, err = j.runGraph(ctx, j.Tasks)
+		} else {
+			failed, err = j.runTasks(ctx, j.Tasks)
+		}
+		cancel()
+		if err != nil {
+			msg := j.Redact(err.Error())
+			j.Store(core.ErrorMessageOutput, msg)
+			j.Store(core.ErrorTaskOutput, failed)
+			j.runTasks(j.ctx, j.OnFailure)
+		}
+		j.runTasks(j.ctx, j.Finally)
+	}()
+}
+
+// runTasks runs tasks in order until one fails, returning its title and error.
+func (j *Job) runTasks(ctx context.Context, tasks []Task) (failed string, err error) {
+	for _, t := range tasks {
+		if err = ctx.Err(); err != nil {
+			log.Printf("Job %d stopped before task %s -> %v\n", j.ID, t.Title, err)
+			return t.Title, err
+		}
+		log.Printf("Running task %s (%s) of job %d\n", t.Title, t.Provider, j.ID)
+		if err = j.runTask(ctx, t); err != nil {
+			log.Printf("Error while executing %s -> %s\n", t.Title, j.Redact(err.Error()))
+			return t.Title, err
+		}
+	}
+	return
+}
+
+// runGraph runs tasks as soon as the tasks listed by their After option have
+// finished, independent tasks run concurrently. Dependencies on tasks before
+// the first one, such as the event that started the job, are already met.
+// The first failure cancels the tasks still running and its title and
+// error are returned once they have stopped.
+func (j *Job) runGraph(ctx context.Context, tasks []Task) (failed string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// waiting counts the unfinished dependencies of every task
+	waiting := make([]int, len(tasks))
+	dependents := make([][]int, len(tasks))
+	for i, t := range tasks {
+		for _, k := range t.Options.After {
+			if k -= j.first; k < 0 || k >= len(tasks) || k == i {
+				continue
+			}
+			waiting[i]++
+			dependents[k] = append(dependents[k], i)
+		}
+	}
+
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(tasks))
+	running, finished := 0, 0
+	start := func(i int) {
+		t := tasks[i]
+		log.Printf("Running task %s (%s) of job %d\n", t.Title, t.Provider, j.ID)
+		running++
+		go func() {
+			results <- result{i, j.runTask(ctx, t)}
+		}()
+	}
+	for i := range tasks {
+		if waiting[i] == 0 {
+			start(i)
+		}
+	}
+	for running > 0 {
+		r := <-results
+		running--
+		finished++
+		if r.err != nil {
+			if err == nil {
+				failed, err = tasks[r.i].Title, r.err
+				log.Printf("Error while executing %s -> %s\n", failed, j.Redact(err.Error()))
+				cancel()
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for _, k := range dependents[r.i] {
+			if waiting[k]--; waiting[k] > 0 {
+				continue
+			}
+			if e := ctx.Err(); e != nil {
+				log.Printf("Job %d stopped before task %s -> %v\n", j.ID, tasks[k].Title, e)
+				failed, err = tasks[k].Title, e
 				break
 			}
+			start(k)
 		}
-	}()
+	}
+	if err == nil && finished < len(tasks) {
+		err = fmt.Errorf("job %d has a dependency cycle, %d of %d tasks did not run", j.ID, len(tasks)-finished, len(tasks))
+	}
+	return
+}
+
+// JobSpec is a dispatched job with a provider for each of its tasks.
+type JobSpec struct {
+	Name      string
+	Options   core.JobOptions
+	Tasks     []Task
+	OnFailure []Task
+	Finally   []Task
 }
 
-func JobFactory() func(int, []Task) func() *Job {
-	id := 0
-	return func(i int, tasks []Task) func() *Job {
+// JobFactory returns a constructor for the jobs of one dispatched job, the
+// jobs start at task i of spec.Tasks. Every job gets its own state and copy
+// of the tasks, so runs of the same job may overlap. They are stopped when
+// ctx is cancelled and ${secret:name} references in them are looked up in
+// secrets.
+func JobFactory(ctx context.Context, spec *JobSpec, secrets SecretStore) func(int) func() *Job {
+	var id int64
+	return func(i int) func() *Job {
 		return func() *Job {
-			j := &Job{id, make(map[string]func() interface{}), tasks[i:]}
-			id += 1
-			return j
+			return &Job{
+				ID:        int(atomic.AddInt64(&id, 1) - 1),
+				State:     NewState(),
+				Tasks:     append([]Task(nil), spec.Tasks[i:]...),
+				OnFailure: append([]Task(nil), spec.OnFailure...),
+				Finally:   append([]Task(nil), spec.Finally...),
+				ctx:       ctx,
+				timeout:   time.Duration(spec.Options.Timeout),
+				parallel:  spec.Options.Parallel,
+				first:     i,
+				secrets:   secrets,
+				jobLog:    new(jobLog),
+			}
 		}
 	}
 }
@@ -73,6 +582,18 @@ type stateParser struct {
 	width  int
 	start  int
 	exps   int
+	// inJSON is set when the input is the JSON of task properties, values
+	// are escaped for the JSON strings they are written to
+	inJSON bool
+	// err is the first expression that did not resolve
+	err error
+}
+
+func (s *stateParser) escape(text string) string {
+	if s.inJSON {
+		return core.JSONEscape(text)
+	}
+	return text
 }
 
 func (s *stateParser) next() rune {
@@ -86,27 +607,57 @@ func (s *stateParser) next() rune {
 	return r
 }
 
-func (s *stateParser) getStateProperty(exp string, j *Job) (property string, ok bool) {
-	if val, exists := j.State[exp]; exists {
-		ok = true
-		property = fmt.Sprint(val())
+func (s *stateParser) replaceReference(j *Job, scheme, name string) {
+	value, ok := j.resolveReference(scheme, name)
+	if !ok {
+		s.output.WriteString(s.input[s.start:s.pos])
 		return
 	}
-	return
+	s.output.WriteString(s.escape(value))
 }
 
-func (s *stateParser) replaceExpression(j *Job) {
-	property, ok := s.getStateProperty(s.input[s.start+2:s.pos-1], j)
+func (s *stateParser) replaceExpression(j *Job, content string) {
+	v, ok, err := j.evalExpression(content)
+	if err == nil && !ok {
+		err = fmt.Errorf("$(%s): no task stored this output, add a default filter if it is optional", content)
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
 	if !ok {
 		s.output.WriteString(s.input[s.start:s.pos])
 		return
 	}
-	s.output.WriteString(property)
+	s.output.WriteString(s.escape(v.String()))
 }
-func (j *Job) InterpolateState(data string) []byte {
+
+// InterpolateState replaces $(Task.Output) expressions in data, the JSON of
+// task properties, with values from the job's state and ${env:NAME} /
+// ${secret:name} references with the environment variable or secret. Both are
+// resolved in a single pass so that values taken from the state are never
+// interpreted themselves, and are escaped for the JSON strings they sit in.
+//
+// An expression may follow the output with a path into its JSON, as in
+// $(Nodes.Result[0].hostname), text outputs are parsed with a .json segment
+// first, as in $(Listen.Body.json.user.id). The value can be piped through
+// filters, as in $(Fetch.Stdout | trim | default "none"). An expression
+// naming no stored output or a path that does not resolve is an error unless
+// a default filter replaces it, so is a failing filter.
+func (j *Job) InterpolateState(data string) ([]byte, error) {
+	return j.interpolate(data, true)
+}
+
+// InterpolateText is InterpolateState for plain text, values are inserted as
+// they are.
+func (j *Job) InterpolateText(data string) (string, error) {
+	b, err := j.interpolate(data, false)
+	return string(b), err
+}
+
+func (j *Job) interpolate(data string, inJSON bool) ([]byte, error) {
 	var s stateParser
 	s.input = data
-	var insideExpression bool
+	s.inJSON = inJSON
 	for {
 		r := s.next()
 		if r == -1 {
@@ -114,14 +665,32 @@ func (j *Job) InterpolateState(data string) []byte {
 		}
 		switch {
 		case strings.HasPrefix(s.input[s.lpos:], "$("):
-			insideExpression = true
+			content, n := core.ScanExpression(s.input[s.lpos:], s.inJSON)
+			if n < 0 {
+				// unterminated, left as it is
+				s.output.WriteString(s.input[s.lpos:])
+				s.pos = len(s.input)
+				continue
+			}
+			s.start = s.lpos
+			s.pos = s.lpos + n
+			s.replaceExpression(j, content)
+		case strings.HasPrefix(s.input[s.lpos:], "${"):
+			scheme, name, n := core.ScanReference(s.input[s.lpos:])
+			if n < 0 {
+				// not a reference, such as ${VAR:-default} in a script
+				s.output.WriteRune(r)
+				continue
+			}
 			s.start = s.lpos
-		case r == ')' && insideExpression:
-			s.replaceExpression(j)
-			insideExpression = false
-		case !insideExpression:
+			s.pos = s.lpos + n
+			s.replaceReference(j, scheme, name)
+		default:
 			s.output.WriteRune(r)
 		}
 	}
-	return s.output.Bytes()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.output.Bytes(), nil
 }
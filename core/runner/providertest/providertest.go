@@ -0,0 +1,252 @@
+// Package providertest runs providers in unit tests, without a runner, RPC or
+// TLS.
+//
+//	var h providertest.Harness
+//	j, err := h.Execute(ctx, providertest.Task(localexec.NewLocalExecProvider(), "Echo", localexec.Settings{
+//		File: "echo",
+//		Args: []core.String{"hello"},
+//	}))
+//	out, _ := j.Load("Echo.Stdout")
+//
+// Event providers are registered with Harness.Register, every job they start
+// is handed out by Events.Next once its run has ended. Events.Fire starts a
+// job as the provider would on an event, without waiting for one:
+//
+//	e := h.Register(ctx, providertest.Task(lp, "In", listener.Settings{Method: "Listen", Path: "/hook"}), respond)
+//	defer e.Close()
+//	e.Fire(map[string]interface{}{"W": rec, "R": req, "Method": "POST"})
+//	j := e.NextWithin(time.Second)
+package providertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kozical/taskengine/core/runner"
+)
+
+// DoneTask is the title of the finally task the harness adds to the jobs of
+// Register and Run to learn that their run has ended. It is the last entry of
+// their history, jobs withdrawn before it runs are not recorded.
+const DoneTask = "providertest.Done"
+
+// Task returns a task of p called title. properties is marshalled to JSON
+// unless it is a string, []byte or json.RawMessage already holding JSON, it
+// panics when properties cannot be marshalled.
+func Task(p runner.Provider, title string, properties interface{}) runner.Task {
+	var raw json.RawMessage
+	switch v := properties.(type) {
+	case nil:
+		raw = json.RawMessage("{}")
+	case string:
+		raw = json.RawMessage(v)
+	case []byte:
+		raw = json.RawMessage(v)
+	case json.RawMessage:
+		raw = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			panic(fmt.Sprintf("providertest: marshalling properties of %s: %v", title, err))
+		}
+		raw = b
+	}
+	return runner.Task{Title: title, Properties: raw, Provider: p}
+}
+
+// Harness builds the jobs running the tasks under test. The zero value is
+// ready to use.
+type Harness struct {
+	// State is stored in every job before its tasks run, such as the outputs
+	// of an event or earlier tasks referenced as $(Task.Output)
+	State map[string]interface{}
+	// Secrets resolves the ${secret:name} references of the tasks, none are
+	// found when it is nil
+	Secrets runner.SecretStore
+}
+
+// Job returns a fresh job of tasks bound to ctx, holding the state of h.
+func (h *Harness) Job(ctx context.Context, tasks ...runner.Task) *runner.Job {
+	return h.jobs(ctx, &runner.JobSpec{Name: "providertest", Tasks: tasks})()
+}
+
+// jobs returns a constructor for the jobs of spec holding the state of h,
+// their IDs count up as those of a dispatched job do.
+func (h *Harness) jobs(ctx context.Context, spec *runner.JobSpec) func() *runner.Job {
+	factory := runner.JobFactory(ctx, spec, h.Secrets)(0)
+	return func() *runner.Job {
+		j := factory()
+		for k, v := range h.State {
+			j.Store(k, v)
+		}
+		return j
+	}
+}
+
+// Execute runs task alone in a fresh job, as the runner would run it, and
+// returns the job holding its outputs along with the error of the provider.
+func (h *Harness) Execute(ctx context.Context, task runner.Task) (*runner.Job, error) {
+	j := h.Job(ctx, task)
+	return j, j.Tasks[0].Execute(ctx, j)
+}
+
+// Run runs tasks as a job started by an event, as if the event had stored the
+// state of h, and returns the job once its run has ended or ctx is done. The
+// error is the first one recorded in the history of the run.
+func (h *Harness) Run(ctx context.Context, tasks ...runner.Task) (*runner.Job, error) {
+	e := newEvents()
+	j := h.jobs(ctx, e.spec(tasks))()
+	j.Run()
+	if j = e.Next(ctx); j == nil {
+		return nil, ctx.Err()
+	}
+	return j, Err(j)
+}
+
+// Err returns the first error recorded in the history of j.
+func Err(j *runner.Job) error {
+	for _, run := range j.History() {
+		if run.Err != nil {
+			return fmt.Errorf("%s: %v", run.Task, run.Err)
+		}
+	}
+	return nil
+}
+
+// Register calls the Register method of the provider of event in the
+// background. The jobs it starts run event followed by tasks, each is
+// recorded once its run has ended. It panics when the provider does not
+// start jobs.
+func (h *Harness) Register(ctx context.Context, event runner.Task, tasks ...runner.Task) *Events {
+	p, ok := event.Provider.(runner.EventProvider)
+	if !ok {
+		panic(fmt.Sprintf("providertest: %s does not implement runner.EventProvider", event.Provider))
+	}
+	e := newEvents()
+	ctx, e.cancel = context.WithCancel(ctx)
+	spec := e.spec(append([]runner.Task{event}, tasks...))
+	fn := h.jobs(ctx, spec)
+	e.event, e.newJob = &spec.Tasks[0], fn
+	go func() {
+		defer close(e.registered)
+		p.Register(ctx, &spec.Tasks[0], fn)
+	}()
+	return e
+}
+
+// Events records the jobs started by an event provider.
+type Events struct {
+	cancel     context.CancelFunc
+	registered chan struct{}
+	// event and newJob are the task and job constructor handed to Register
+	event  *runner.Task
+	newJob func() *runner.Job
+
+	mu    sync.Mutex
+	jobs  []*runner.Job
+	fired chan struct{}
+}
+
+func newEvents() *Events {
+	return &Events{
+		cancel:     func() {},
+		registered: make(chan struct{}),
+		fired:      make(chan struct{}, 1),
+	}
+}
+
+// spec returns the spec of the jobs of tasks, whose finally task records them.
+func (e *Events) spec(tasks []runner.Task) *runner.JobSpec {
+	return &runner.JobSpec{
+		Name:    "providertest",
+		Tasks:   tasks,
+		Finally: []runner.Task{{Title: DoneTask, Properties: json.RawMessage("{}"), Provider: recorder{e}}},
+	}
+}
+
+func (e *Events) record(j *runner.Job) {
+	e.mu.Lock()
+	e.jobs = append(e.jobs, j)
+	e.mu.Unlock()
+	select {
+	case e.fired <- struct{}{}:
+	default:
+	}
+}
+
+// Fire starts a job as the event provider would when its event occurs, with
+// outputs published as the outputs of the event task, such as the W and R of
+// a listener request. The job is handed out by Next once its run has ended.
+// It panics unless e was returned by Harness.Register.
+func (e *Events) Fire(outputs map[string]interface{}) *runner.Job {
+	if e.newJob == nil {
+		panic("providertest: Fire requires the Events of Harness.Register")
+	}
+	j := e.newJob()
+	for name, v := range outputs {
+		j.Publish(e.event, name, v)
+	}
+	j.Run()
+	return j
+}
+
+// Next returns the oldest job whose run has ended, waiting for one until ctx
+// is done or Register has returned. It returns nil when there is none.
+func (e *Events) Next(ctx context.Context) *runner.Job {
+	for {
+		e.mu.Lock()
+		if len(e.jobs) > 0 {
+			j := e.jobs[0]
+			e.jobs = e.jobs[1:]
+			e.mu.Unlock()
+			return j
+		}
+		e.mu.Unlock()
+		select {
+		case <-e.fired:
+		case <-e.registered:
+			// a job may have ended while Register was returning
+			select {
+			case <-e.fired:
+			default:
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NextWithin is Next bounded by timeout.
+func (e *Events) NextWithin(timeout time.Duration) *runner.Job {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return e.Next(ctx)
+}
+
+// Close cancels the registration and waits for Register to return.
+func (e *Events) Close() {
+	e.cancel()
+	<-e.registered
+}
+
+// recorder is the provider of DoneTask.
+type recorder struct {
+	e *Events
+}
+
+func (r recorder) String() string {
+	return "providertest"
+}
+
+// Execute records j once its run, this task included, has ended.
+func (r recorder) Execute(j *runner.Job) error {
+	go func() {
+		<-j.Done()
+		r.e.record(j)
+	}()
+	return nil
+}
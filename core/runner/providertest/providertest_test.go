@@ -0,0 +1,130 @@
+package providertest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+	"github.com/Kozical/taskengine/providers/listener"
+	"github.com/Kozical/taskengine/providers/localexec"
+	"github.com/Kozical/taskengine/providers/ticker"
+)
+
+func echo(title string, args ...core.String) runner.Task {
+	return providertest.Task(localexec.NewLocalExecProvider(), title, localexec.Settings{File: "echo", Args: args})
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  map[string]interface{}
+		args   []core.String
+		stdout string
+		err    bool
+	}{
+		{name: "plain", args: []core.String{"hello"}, stdout: "hello\n"},
+		{name: "state", state: map[string]interface{}{"In.Name": "world"}, args: []core.String{"hello", "$(In.Name)"}, stdout: "hello world\n"},
+		{name: "no args", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := providertest.Harness{State: tt.state}
+			j, err := h.Execute(context.Background(), echo("Echo", tt.args...))
+			if tt.err {
+				if err == nil {
+					t.Fatal("Execute succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out, _ := j.Load("Echo.Stdout"); out != tt.stdout {
+				t.Errorf("Stdout = %q, want %q", out, tt.stdout)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	h := providertest.Harness{State: map[string]interface{}{"In.Name": "world"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := h.Run(ctx, echo("First", "$(In.Name)"), echo("Second", "$(First.Stdout | trim)!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := j.Load("Second.Stdout"); out != "world!\n" {
+		t.Errorf("Stdout = %q", out)
+	}
+	if h := j.History(); len(h) == 0 || h[len(h)-1].Task != providertest.DoneTask {
+		t.Errorf("history = %+v, want it to end with %s", h, providertest.DoneTask)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var h providertest.Harness
+	event := providertest.Task(ticker.NewTickerProvider(), "Tick", ticker.Settings{Every: core.Duration(10 * time.Millisecond)})
+	e := h.Register(context.Background(), event, echo("Echo", "tick"))
+	for i := 0; i < 2; i++ {
+		j := e.NextWithin(5 * time.Second)
+		if j == nil {
+			t.Fatalf("tick %d did not start a job", i)
+		}
+		if err := providertest.Err(j); err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := j.Load("Echo.Stdout"); out != "tick\n" {
+			t.Errorf("tick %d: Stdout = %q", i, out)
+		}
+	}
+	e.Close()
+	// jobs of ticks before Close may still be recorded, none after
+	for e.NextWithin(100*time.Millisecond) != nil {
+	}
+	if j := e.NextWithin(100 * time.Millisecond); j != nil {
+		t.Error("a job started after Close")
+	}
+}
+
+func TestFire(t *testing.T) {
+	var h providertest.Harness
+	lp := new(listener.ListenerProvider)
+	event := providertest.Task(lp, "In", listener.Settings{Method: "Listen", Path: "/providertest"})
+	respond := providertest.Task(lp, "In", listener.Settings{Method: "Respond", Response: "hello $(In.Body)"})
+	e := h.Register(context.Background(), event, echo("Echo", "$(In.Method)"), respond)
+	defer e.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/providertest", strings.NewReader("world"))
+	e.Fire(map[string]interface{}{"W": rec, "R": req, "Body": "world", "Method": req.Method})
+	j := e.NextWithin(5 * time.Second)
+	if j == nil {
+		t.Fatal("Fire did not start a job")
+	}
+	if err := providertest.Err(j); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := j.Load("Echo.Stdout"); out != "POST\n" {
+		t.Errorf("Stdout = %q", out)
+	}
+	if body := rec.Body.String(); body != "hello world" {
+		t.Errorf("response = %q, want %q", body, "hello world")
+	}
+}
+
+func TestFireWithoutRegister(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Fire without Register did not panic")
+		}
+	}()
+	var e providertest.Events
+	e.Fire(nil)
+}
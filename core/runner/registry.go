@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// ProviderInfo registers a provider with a Runner. Jobs refer to it by Name
+// or one of its Aliases, both are matched without regard to case.
+type ProviderInfo struct {
+	Name    string
+	Aliases []string
+	Version string
+	Schema  core.Schema
+	// New returns the provider of one task of a dispatched job. Providers
+	// keep no per-task or per-run state, their task is passed to them, so
+	// New may return the same configured provider every time. It is not sent
+	// over RPC.
+	New func() (Provider, error)
+}
+
+// Register adds the provider described by info, failing when its name or one
+// of its aliases is already taken.
+func (r *Runner) Register(info ProviderInfo) error {
+	if len(info.Name) == 0 {
+		return fmt.Errorf("provider has no name")
+	}
+	if info.New == nil {
+		return fmt.Errorf("provider %s has no New function", info.Name)
+	}
+	r.muProviders.Lock()
+	defer r.muProviders.Unlock()
+	if r.providers == nil {
+		r.providers = make(map[string]*ProviderInfo)
+	}
+	names := append([]string{info.Name}, info.Aliases...)
+	for _, name := range names {
+		if p, ok := r.providers[strings.ToLower(name)]; ok {
+			return fmt.Errorf("provider name %s is already taken by %s", name, p.Name)
+		}
+	}
+	for _, name := range names {
+		r.providers[strings.ToLower(name)] = &info
+	}
+	return nil
+}
+
+// Lookup returns the provider registered under name or one of its aliases.
+func (r *Runner) Lookup(name string) (info ProviderInfo, ok bool) {
+	r.muProviders.RLock()
+	defer r.muProviders.RUnlock()
+	p, ok := r.providers[strings.ToLower(name)]
+	if ok {
+		info = *p
+	}
+	return
+}
+
+// NewProvider returns a provider for a task of the provider called name.
+func (r *Runner) NewProvider(name string) (Provider, error) {
+	info, ok := r.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("Provider %s not found", name)
+	}
+	p, err := info.New()
+	if err != nil {
+		return nil, fmt.Errorf("Provider %s: %v", info.Name, err)
+	}
+	return p, nil
+}
+
+// Providers lists the registered providers sorted by name.
+func (r *Runner) Providers() (providers []ProviderInfo) {
+	r.muProviders.RLock()
+	defer r.muProviders.RUnlock()
+	for key, p := range r.providers {
+		if key == strings.ToLower(p.Name) {
+			providers = append(providers, *p)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+	return
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterProviders(t *testing.T) {
+	r := NewRunner()
+	p := &eventProvider{}
+	newP := func() (Provider, error) { return p, nil }
+	tests := []struct {
+		info ProviderInfo
+		ok   bool
+	}{
+		{ProviderInfo{Name: "listener", Aliases: []string{"listener_event"}, New: newP}, true},
+		{ProviderInfo{Name: "Listener_Event", New: newP}, false},
+		{ProviderInfo{Name: "other", Aliases: []string{"LISTENER"}, New: newP}, false},
+		{ProviderInfo{Name: "", New: newP}, false},
+		{ProviderInfo{Name: "nonew"}, false},
+		{ProviderInfo{Name: "ticker", New: newP}, true},
+	}
+	for _, tt := range tests {
+		if err := r.Register(tt.info); (err == nil) != tt.ok {
+			t.Errorf("Register(%s) error = %v, want ok %v", tt.info.Name, err, tt.ok)
+		}
+	}
+	if info, ok := r.Lookup("LISTENER_event"); !ok || info.Name != "listener" {
+		t.Errorf("Lookup of an alias = %+v, %v", info, ok)
+	}
+	var names []string
+	for _, info := range r.Providers() {
+		names = append(names, info.Name)
+	}
+	if len(names) != 2 || names[0] != "listener" || names[1] != "ticker" {
+		t.Errorf("Providers = %q", names)
+	}
+	if _, err := r.NewProvider("missing"); err == nil {
+		t.Error("NewProvider of an unknown provider succeeded")
+	}
+}
+
+// titleProvider only implements Execute and records the properties of the
+// tasks it is handed.
+type titleProvider struct {
+	mu    sync.Mutex
+	props []string
+}
+
+func (p *titleProvider) Execute(j *Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.props = append(p.props, string(j.Task().Properties))
+	return nil
+}
+
+func TestSharedProvider(t *testing.T) {
+	r := NewRunner()
+	p := new(titleProvider)
+	if err := r.Register(ProviderInfo{Name: "shared", New: func() (Provider, error) { return p, nil }}); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []Task
+	for _, props := range []string{`{"Method":"Listen"}`, `{"Method":"Respond"}`} {
+		provider, err := r.NewProvider("shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, Task{Title: "In", Properties: json.RawMessage(props), Provider: provider})
+	}
+	j := JobFactory(context.Background(), &JobSpec{Name: "test", Tasks: tasks}, nil)(0)()
+	j.Run()
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not finish")
+	}
+	if want := []string{`{"Method":"Listen"}`, `{"Method":"Respond"}`}; !reflect.DeepEqual(p.props, want) {
+		t.Errorf("tasks = %q, want %q", p.props, want)
+	}
+	if j.Task() != nil {
+		t.Error("a job outside of Task.Execute has a task")
+	}
+}
@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// flakyProvider fails the first failures runs with err, or with a timeout
+// when err is nil.
+type flakyProvider struct {
+	mu       sync.Mutex
+	failures int
+	runs     int
+	err      error
+}
+
+func (p *flakyProvider) String() string {
+	return "flaky"
+}
+
+func (p *flakyProvider) Execute(j *Job) error {
+	return nil
+}
+
+func (p *flakyProvider) ExecuteContext(ctx context.Context, j *Job, t *Task) error {
+	p.mu.Lock()
+	p.runs++
+	fail := p.runs <= p.failures
+	p.mu.Unlock()
+	if !fail {
+		return nil
+	}
+	if p.err == nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.err
+}
+
+func TestRetry(t *testing.T) {
+	ms := core.Duration(time.Millisecond)
+	tests := []struct {
+		name     string
+		provider *flakyProvider
+		options  core.TaskOptions
+		attempts int
+		failed   bool
+	}{
+		{
+			name:     "not retried",
+			provider: &flakyProvider{failures: 1, err: errors.New("down")},
+			attempts: 1,
+			failed:   true,
+		},
+		{
+			name:     "succeeds on a retry",
+			provider: &flakyProvider{failures: 2, err: errors.New("down")},
+			options:  core.TaskOptions{Retry: &core.RetryPolicy{Delay: ms}},
+			attempts: 3,
+		},
+		{
+			name:     "out of attempts",
+			provider: &flakyProvider{failures: 5, err: errors.New("down")},
+			options:  core.TaskOptions{Retry: &core.RetryPolicy{Attempts: 2, Delay: ms}},
+			attempts: 2,
+			failed:   true,
+		},
+		{
+			name:     "class not retried",
+			provider: &flakyProvider{failures: 1, err: errors.New("down")},
+			options:  core.TaskOptions{Retry: &core.RetryPolicy{Delay: ms, On: []core.String{ClassTimeout}}},
+			attempts: 1,
+			failed:   true,
+		},
+		{
+			name:     "timeouts retried",
+			provider: &flakyProvider{failures: 1},
+			options:  core.TaskOptions{Timeout: 10 * ms, Retry: &core.RetryPolicy{Delay: ms, On: []core.String{ClassTimeout}}},
+			attempts: 2,
+		},
+		{
+			name:     "provider class",
+			provider: &flakyProvider{failures: 1, err: ClassifyError("busy", errors.New("try later"))},
+			options:  core.TaskOptions{Retry: &core.RetryPolicy{Delay: ms, On: []core.String{"busy"}}},
+			attempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newTestJob(nil, Task{Title: "Flaky", Properties: []byte("{}"), Provider: tt.provider, Options: tt.options})
+			err := j.runTask(context.Background(), j.Tasks[0])
+			if failed := err != nil; failed != tt.failed {
+				t.Errorf("error = %v, want failed %t", err, tt.failed)
+			}
+			history := j.History()
+			if len(history) != tt.attempts {
+				t.Fatalf("%d runs recorded, want %d", len(history), tt.attempts)
+			}
+			for i, run := range history {
+				if run.Attempt != i+1 || (run.Err == nil) != (i == tt.attempts-1 && !tt.failed) {
+					t.Errorf("run %d: attempt %d, error %v", i, run.Attempt, run.Err)
+				}
+			}
+			if n, _ := j.Load("Flaky.Attempts"); fmt.Sprint(n) != fmt.Sprint(tt.attempts) {
+				t.Errorf("Flaky.Attempts = %v, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	p := &flakyProvider{failures: 5, err: errors.New("down")}
+	j := newTestJob(nil, Task{Title: "Flaky", Properties: []byte("{}"), Provider: p, Options: core.TaskOptions{
+		Retry: &core.RetryPolicy{Delay: core.Duration(time.Hour)},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- j.runTask(ctx, j.Tasks[0])
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("a cancelled retry did not fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling the job did not interrupt the wait between attempts")
+	}
+	if len(j.History()) != 1 {
+		t.Errorf("%d attempts, want 1", len(j.History()))
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"reflect"
 	"sync/atomic"
 	"syscall"
 
@@ -64,7 +63,7 @@ func (r RPCServer) ListenAndServeTLS(addr string, config *tls.Config) (err error
 		var conn net.Conn
 		conn, err = lst.Accept()
 		if err != nil {
-			fmt.Errorf("Error while accepting connection -> %v\n", err)
+			log.Printf("Error while accepting connection -> %v\n", err)
 		}
 		select {
 		case <-r.quit:
@@ -87,6 +86,12 @@ type RPCTask struct {
 	T *Runner
 }
 
+// Providers lists the providers registered on the runner.
+func (r RPCTask) Providers(req *[]byte, res *[]ProviderInfo) (err error) {
+	*res = r.T.Providers()
+	return
+}
+
 func (r RPCTask) Ping(req *[]byte, res *[]byte) (err error) {
 	if string(*req) == "Ping!" {
 		*res = []byte("Pong!")
@@ -94,30 +99,66 @@ func (r RPCTask) Ping(req *[]byte, res *[]byte) (err error) {
 	return
 }
 
+// Dispatch starts j, failing when a job of the same name is already
+// dispatched, changed jobs are swapped in with Replace.
 func (r RPCTask) Dispatch(j *core.RPCJob, res *[]byte) (err error) {
-	var tasks []Task
 	log.Printf("Dispatching job %s\n", j.Name)
-	for _, v := range j.Objects {
-		base := r.T.GetProvider(v.Provider)
-		if base == nil {
-			err = fmt.Errorf("Provider %s not found\n", v.Provider)
+	return r.start(j, r.T.Start)
+}
+
+// Undispatch withdraws the job called name, stopping its event registrations
+// and any run in progress.
+func (r RPCTask) Undispatch(name *string, res *[]byte) (err error) {
+	log.Printf("Withdrawing job %s\n", *name)
+	if !r.T.Stop(*name) {
+		err = fmt.Errorf("Job %s is not dispatched\n", *name)
+	}
+	return
+}
+
+// Replace swaps the running job of the same name for j, failing when no such
+// job is dispatched. The old job keeps running when j refers to an unknown
+// provider.
+func (r RPCTask) Replace(j *core.RPCJob, res *[]byte) (err error) {
+	log.Printf("Replacing job %s\n", j.Name)
+	return r.start(j, r.T.Replace)
+}
+
+// start hands the spec of j to fn, Runner.Start or Runner.Replace.
+func (r RPCTask) start(j *core.RPCJob, fn func(*JobSpec) error) error {
+	spec, err := r.spec(j)
+	if err != nil {
+		return err
+	}
+	return fn(spec)
+}
+
+// spec creates a fresh provider for every object of j.
+func (r RPCTask) spec(j *core.RPCJob) (spec *JobSpec, err error) {
+	spec = &JobSpec{Name: j.Name, Options: j.Options}
+	if spec.Tasks, err = r.tasks(j.Objects); err != nil {
+		return
+	}
+	if spec.OnFailure, err = r.tasks(j.OnFailure); err != nil {
+		return
+	}
+	spec.Finally, err = r.tasks(j.Finally)
+	return
+}
+
+func (r RPCTask) tasks(objects []core.ParseObject) (tasks []Task, err error) {
+	for _, v := range objects {
+		var provider Provider
+		if provider, err = r.T.NewProvider(v.Provider); err != nil {
 			return
 		}
-		var provider Provider
-		provider = reflect.New(reflect.ValueOf(base).Elem().Type()).Interface().(Provider)
 		tasks = append(tasks, Task{
 			Title:      v.Name,
 			Properties: core.JSONPromote(v.Properties),
 			Provider:   provider,
+			Options:    v.Options,
 		})
 	}
-	factory := JobFactory()
-
-	for i, t := range tasks {
-		if event, ok := t.Provider.(EventProvider); ok {
-			go event.Register(factory(i, tasks))
-		}
-	}
 	return
 }
 
@@ -134,7 +175,7 @@ func (r RPCTask) Execute(req *RPCExec, res *[]byte) (err error) {
 		return
 	}
 
-	intC := make(chan os.Signal)
+	intC := make(chan os.Signal, 1)
 	waitC := make(chan error, 1)
 
 	signal.Notify(intC, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
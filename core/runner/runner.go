@@ -1,13 +1,33 @@
 package runner
 
 import (
+	"context"
 	"fmt"
-	"reflect"
-	"strings"
+	"sync"
 )
 
 type Runner struct {
-	providers []Provider
+	muProviders sync.RWMutex
+	// providers holds every provider under its name and aliases, in lower
+	// case
+	providers map[string]*ProviderInfo
+	// Secrets resolves the ${secret:name} references of dispatched jobs
+	Secrets SecretStore
+
+	muJobs sync.Mutex
+	jobs   map[string]*dispatch
+}
+
+// dispatch is a job whose event registrations are running on this runner.
+type dispatch struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// stop cancels the job and waits until its event registrations are released.
+func (d *dispatch) stop() {
+	d.cancel()
+	d.wg.Wait()
 }
 
 func NewRunner() (r *Runner) {
@@ -15,17 +35,70 @@ func NewRunner() (r *Runner) {
 	return
 }
 
-func (r *Runner) RegisterProviders(providers ...Provider) {
-	for _, p := range providers {
-		r.providers = append(r.providers, p)
-	}
+// Start registers the event tasks of spec, failing when a job of the same
+// name is already running.
+func (r *Runner) Start(spec *JobSpec) error {
+	return r.start(spec, false)
+}
+
+// Replace stops the running job of the same name as spec and registers the
+// event tasks of spec in its place, failing when no such job is running.
+func (r *Runner) Replace(spec *JobSpec) error {
+	return r.start(spec, true)
 }
 
-func (r *Runner) GetProvider(name string) Provider {
-	for _, p := range r.providers {
-		if strings.HasSuffix(strings.ToLower(reflect.TypeOf(p).String()), fmt.Sprintf("%s%s", strings.ToLower(name), "provider")) {
-			return p
+// start registers the event tasks of spec, replace tells whether a job of
+// the same name must be running or must not.
+func (r *Runner) start(spec *JobSpec, replace bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &dispatch{cancel: cancel}
+
+	r.muJobs.Lock()
+	if r.jobs == nil {
+		r.jobs = make(map[string]*dispatch)
+	}
+	old, running := r.jobs[spec.Name]
+	if running != replace {
+		r.muJobs.Unlock()
+		cancel()
+		if replace {
+			return fmt.Errorf("Job %s is not dispatched", spec.Name)
+		}
+		return fmt.Errorf("Job %s is already dispatched", spec.Name)
+	}
+	r.jobs[spec.Name] = d
+	r.muJobs.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+
+	factory := JobFactory(ctx, spec, r.Secrets)
+	for i := range spec.Tasks {
+		t := spec.Tasks[i]
+		if event, ok := t.Provider.(EventProvider); ok {
+			d.wg.Add(1)
+			go func(fn func() *Job) {
+				defer d.wg.Done()
+				event.Register(ctx, &t, fn)
+			}(factory(i))
 		}
 	}
 	return nil
 }
+
+// Stop withdraws the job called name: its event registrations are released
+// and runs in progress end before their next task. It reports whether the
+// job was running.
+func (r *Runner) Stop(name string) bool {
+	r.muJobs.Lock()
+	d, ok := r.jobs[name]
+	delete(r.jobs, name)
+	r.muJobs.Unlock()
+
+	if !ok {
+		return false
+	}
+	d.stop()
+	return true
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// eventProvider counts the tasks registered with it until their job is
+// withdrawn.
+type eventProvider struct {
+	mu         sync.Mutex
+	registered map[string]int
+}
+
+func (p *eventProvider) Execute(j *Job) error {
+	return nil
+}
+
+func (p *eventProvider) Register(ctx context.Context, t *Task, fn func() *Job) {
+	p.mu.Lock()
+	p.registered[t.Title]++
+	p.mu.Unlock()
+	<-ctx.Done()
+	p.mu.Lock()
+	p.registered[t.Title]--
+	p.mu.Unlock()
+}
+
+func (p *eventProvider) count(title string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registered[title]
+}
+
+func rpcJob(name, title string) *core.RPCJob {
+	return &core.RPCJob{
+		Name:    name,
+		Objects: []core.ParseObject{{Provider: "event", Name: title, Properties: json.RawMessage("{}")}},
+	}
+}
+
+func TestDispatchReplaceUndispatch(t *testing.T) {
+	p := &eventProvider{registered: make(map[string]int)}
+	r := NewRunner()
+	if err := r.Register(ProviderInfo{Name: "event", New: func() (Provider, error) { return p, nil }}); err != nil {
+		t.Fatal(err)
+	}
+	rt := RPCTask{T: r}
+	var res []byte
+	name := "rpc.job"
+
+	if err := rt.Replace(rpcJob(name, "Old"), &res); err == nil {
+		t.Error("Replace of a job that was never dispatched succeeded")
+	}
+	if err := rt.Dispatch(rpcJob(name, "Old"), &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Dispatch(rpcJob(name, "Other"), &res); err == nil {
+		t.Error("Dispatch of a job that is already dispatched succeeded")
+	}
+	if err := rt.Replace(rpcJob(name, "New"), &res); err != nil {
+		t.Fatal(err)
+	}
+	// the old registration is released before Replace returns
+	if p.count("Old") != 0 {
+		t.Error("Replace left the old registration running")
+	}
+	if err := rt.Replace(&core.RPCJob{Name: name, Objects: []core.ParseObject{{Provider: "unknown", Name: "X"}}}, &res); err == nil {
+		t.Error("Replace with an unknown provider succeeded")
+	}
+	if err := rt.Undispatch(&name, &res); err != nil {
+		t.Fatal(err)
+	}
+	if p.count("New") != 0 {
+		t.Error("Undispatch left the registration running")
+	}
+	if err := rt.Undispatch(&name, &res); err == nil {
+		t.Error("Undispatch of a withdrawn job succeeded")
+	}
+	if err := rt.Dispatch(rpcJob(name, "Again"), &res); err != nil {
+		t.Errorf("Dispatch after Undispatch: %v", err)
+	}
+	r.Stop(name)
+}
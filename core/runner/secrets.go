@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore looks up the secrets referenced as ${secret:name} in job
+// properties and provider configuration.
+type SecretStore interface {
+	Secret(name string) (string, error)
+}
+
+// EnvSecretStore reads secrets from environment variables, the secret
+// db_pass is read from <Prefix>DB_PASS.
+type EnvSecretStore struct {
+	Prefix string
+}
+
+func (s EnvSecretStore) Secret(name string) (string, error) {
+	v, ok := os.LookupEnv(s.Prefix + strings.ToUpper(name))
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return v, nil
+}
+
+// FileSecretStore reads every secret from a file of the same name in Dir, as
+// secrets are mounted by docker and kubernetes. A trailing newline is removed.
+type FileSecretStore struct {
+	Dir string
+}
+
+func (s FileSecretStore) Secret(name string) (string, error) {
+	if len(name) == 0 || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// lookupReference resolves a ${scheme:name} reference.
+func lookupReference(scheme, name string, secrets SecretStore) (value string, err error) {
+	switch scheme {
+	case "env":
+		var ok bool
+		if value, ok = os.LookupEnv(name); !ok {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+	case "secret":
+		if secrets == nil {
+			err = fmt.Errorf("no secret store configured for secret %s", name)
+			return
+		}
+		if value, err = secrets.Secret(name); err != nil {
+			err = fmt.Errorf("secret %s: %v", name, err)
+		}
+	default:
+		err = fmt.Errorf("unknown reference ${%s:%s}", scheme, name)
+	}
+	return
+}
+
+// ResolveReferences replaces the ${env:NAME} and ${secret:name} references in
+// data, failing on any it cannot resolve. It is meant for provider
+// configuration files, job properties are resolved by Job.InterpolateState.
+func ResolveReferences(data string, secrets SecretStore) (string, error) {
+	return core.ExpandReferences(data, func(scheme, name string) (string, bool, error) {
+		value, err := lookupReference(scheme, name, secrets)
+		return value, err == nil, err
+	})
+}
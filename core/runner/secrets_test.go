@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+// mapSecrets is a SecretStore holding its secrets in memory.
+type mapSecrets map[string]string
+
+func (m mapSecrets) Secret(name string) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return v, nil
+}
+
+func newTestJob(secrets SecretStore, tasks ...Task) *Job {
+	return JobFactory(context.Background(), &JobSpec{Name: "test", Tasks: tasks}, secrets)(0)()
+}
+
+func TestInterpolateReferences(t *testing.T) {
+	os.Setenv("TASKENGINE_TEST_USER", "admin")
+	defer os.Unsetenv("TASKENGINE_TEST_USER")
+	secrets := mapSecrets{"token": "s3cr\"t"}
+	tests := []struct {
+		data, want string
+	}{
+		{`{"User": "${env:TASKENGINE_TEST_USER}"}`, `{"User": "admin"}`},
+		{`{"Token": "${secret:token}"}`, `{"Token": "s3cr\"t"}`},
+		{`{"Script": "echo ${NAME:-world} ${HOME}"}`, `{"Script": "echo ${NAME:-world} ${HOME}"}`},
+		{`{"Missing": "${env:TASKENGINE_TEST_UNSET}"}`, `{"Missing": "${env:TASKENGINE_TEST_UNSET}"}`},
+		{`{"Open": "${env:TASKENGINE_TEST_USER"}`, `{"Open": "${env:TASKENGINE_TEST_USER"}`},
+	}
+	for _, tt := range tests {
+		got, err := newTestJob(secrets).InterpolateState(tt.data)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("InterpolateState(%s) = %s, %v, want %s", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	j := newTestJob(mapSecrets{"token": "hunter2"})
+	if _, err := j.InterpolateState(`{"Token": "${secret:token}"}`); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.Redact("login failed for hunter2"); got != "login failed for ******" {
+		t.Errorf("Redact = %q", got)
+	}
+	if s := newTestJob(nil).Redact("hunter2"); s != "hunter2" {
+		t.Errorf("Redact of a job without secrets = %q", s)
+	}
+}
+
+func TestDecodeRedactsSecrets(t *testing.T) {
+	task := Task{Title: "Tick", Properties: []byte(`{"Interval": "${secret:token}"}`)}
+	j := newTestJob(mapSecrets{"token": "hunter2"}, task)
+	var settings struct {
+		Interval core.Int
+	}
+	err := j.Decode(&j.Tasks[0], &settings)
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "******") {
+		t.Errorf("error = %v, want the secret masked", err)
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	secrets := mapSecrets{"pass": "pw"}
+	if got, err := ResolveReferences("${secret:pass}", secrets); err != nil || got != "pw" {
+		t.Errorf("ResolveReferences = %q, %v", got, err)
+	}
+	if _, err := ResolveReferences("${secret:missing}", secrets); err == nil {
+		t.Error("ResolveReferences of a missing secret succeeded")
+	}
+	if got, err := ResolveReferences("${USER:-x}", secrets); err != nil || got != "${USER:-x}" {
+		t.Errorf("ResolveReferences of a shell variable = %q, %v", got, err)
+	}
+}
+
+func TestFileSecretStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "db_pass"), []byte("pw\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := FileSecretStore{Dir: dir}
+	if v, err := s.Secret("db_pass"); err != nil || v != "pw" {
+		t.Errorf("Secret(db_pass) = %q, %v", v, err)
+	}
+	if _, err := s.Secret("missing"); err != ErrSecretNotFound {
+		t.Errorf("Secret(missing) error = %v, want ErrSecretNotFound", err)
+	}
+	for _, name := range []string{"../db_pass", ".hidden", ""} {
+		if _, err := s.Secret(name); err == nil || strings.Contains(err.Error(), "not found") {
+			t.Errorf("Secret(%q) error = %v, want an invalid name", name, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+func TestOnFailureAndFinally(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel bool
+		fail     bool
+		steps    []string
+		message  string
+	}{
+		{name: "success", steps: []string{"first", "second", "cleanup"}},
+		{name: "failure", fail: true, steps: []string{"first", "alert failed Bad Bad", "cleanup"}, message: "failed Bad"},
+		{name: "failure in a graph", parallel: true, fail: true, steps: []string{"first", "alert failed Bad Bad", "cleanup"}, message: "failed Bad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := new(itemProvider)
+			task := func(title, props string, after ...int) Task {
+				return Task{Title: title, Properties: json.RawMessage(props), Provider: p, Options: core.TaskOptions{After: after}}
+			}
+			tasks := []Task{task("First", `{"Name": "first"}`)}
+			if tt.fail {
+				tasks = append(tasks, task("Bad", `{"Name": "Bad", "Fail": true}`, 0))
+			}
+			tasks = append(tasks, task("Second", `{"Name": "second"}`, 0, 1))
+			spec := &JobSpec{
+				Name:      "test",
+				Options:   core.JobOptions{Parallel: tt.parallel},
+				Tasks:     tasks,
+				OnFailure: []Task{task("Alert", `{"Name": "alert $(error.Message) $(error.Task)"}`)},
+				Finally:   []Task{task("Cleanup", `{"Name": "cleanup"}`)},
+			}
+			j := JobFactory(context.Background(), spec, nil)(0)()
+			j.Run()
+			select {
+			case <-j.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the job did not finish")
+			}
+			var steps []string
+			for _, run := range j.History() {
+				if run.Err == nil {
+					out, _ := j.Load(run.Task + ".Out")
+					steps = append(steps, out.(string))
+				}
+			}
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("steps = %q, want %q", steps, tt.steps)
+			}
+			if msg, _ := j.Load(core.ErrorMessageOutput); len(tt.message) > 0 && msg != tt.message {
+				t.Errorf("%s = %v, want %s", core.ErrorMessageOutput, msg, tt.message)
+			}
+		})
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		p := new(itemProvider)
+		task := func(title, props string, after ...int) Task {
+			return Task{Title: title, Properties: json.RawMessage(props), Provider: p, Options: core.TaskOptions{After: after}}
+		}
+		spec := &JobSpec{
+			Name:    "test",
+			Options: core.JobOptions{Parallel: parallel, Timeout: core.Duration(100 * time.Millisecond)},
+			Tasks: []Task{
+				task("First", `{"Name": "first"}`),
+				task("Slow", `{"Name": "slow", "Sleep": "10s"}`, 0),
+				task("Never", `{"Name": "never"}`, 1),
+			},
+			OnFailure: []Task{task("Alert", `{"Name": "alert"}`)},
+			Finally:   []Task{task("Cleanup", `{"Name": "cleanup"}`)},
+		}
+		j := JobFactory(context.Background(), spec, nil)(0)()
+		j.Run()
+		select {
+		case <-j.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("parallel %v: the job did not stop at its timeout", parallel)
+		}
+		var steps []string
+		for _, run := range j.History() {
+			if run.Err == nil {
+				out, _ := j.Load(run.Task + ".Out")
+				steps = append(steps, out.(string))
+			}
+		}
+		// the sections run once the timeout stopped the job
+		if want := []string{"first", "alert", "cleanup"}; !reflect.DeepEqual(steps, want) {
+			t.Errorf("parallel %v: steps = %q, want %q", parallel, steps, want)
+		}
+		failed, _ := j.Load(core.ErrorTaskOutput)
+		msg, _ := j.Load(core.ErrorMessageOutput)
+		if failed != "Slow" || !strings.Contains(fmt.Sprint(msg), "deadline exceeded") {
+			t.Errorf("parallel %v: error of %v = %v, want the timeout of Slow", parallel, failed, msg)
+		}
+	}
+}
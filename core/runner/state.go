@@ -0,0 +1,301 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the type of a value in the state of a job.
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindNumber
+	KindBool
+	KindBytes
+	// KindJSON values hold a JSON document, such as the Result of a mongo task
+	KindJSON
+	// KindObject values hold any other Go value handed from one task to the
+	// next, such as the http.ResponseWriter of a listener request
+	KindObject
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindNumber:
+		return "number"
+	case KindBool:
+		return "bool"
+	case KindBytes:
+		return "bytes"
+	case KindJSON:
+		return "json"
+	case KindObject:
+		return "object"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// kindName names kind in errors.
+func kindName(kind Kind) string {
+	switch kind {
+	case KindBytes:
+		return "bytes"
+	case KindJSON:
+		return "a JSON document"
+	case KindObject:
+		return "a Go value"
+	}
+	return "a " + kind.String()
+}
+
+// Value is an entry of the state of a job. Besides the value itself it
+// records the task that stored it and when.
+type Value struct {
+	Kind Kind
+	// Task is the title of the task that stored the value, empty for values
+	// of the runner such as error.Message
+	Task string
+	Time time.Time
+
+	v    interface{}
+	lazy *lazyValue
+}
+
+// lazyValue is computed on first use, once for every copy of the Value.
+type lazyValue struct {
+	once sync.Once
+	fn   func() interface{}
+	v    interface{}
+	done int32
+}
+
+func (l *lazyValue) value() interface{} {
+	l.once.Do(func() {
+		l.v = l.fn()
+		atomic.StoreInt32(&l.done, 1)
+	})
+	return l.v
+}
+
+func (l *lazyValue) computed() bool {
+	return atomic.LoadInt32(&l.done) == 1
+}
+
+func StringValue(s string) Value {
+	return Value{Kind: KindString, v: s}
+}
+
+func NumberValue(f float64) Value {
+	return Value{Kind: KindNumber, v: f}
+}
+
+func BoolValue(b bool) Value {
+	return Value{Kind: KindBool, v: b}
+}
+
+func BytesValue(b []byte) Value {
+	return Value{Kind: KindBytes, v: b}
+}
+
+// JSONValue holds the JSON document doc, which is not checked.
+func JSONValue(doc json.RawMessage) Value {
+	return Value{Kind: KindJSON, v: doc}
+}
+
+func ObjectValue(v interface{}) Value {
+	return Value{Kind: KindObject, v: v}
+}
+
+// LazyValue is computed by fn when it is first used, fn must return a value
+// of the Go type of kind. Reading a request body is only done when a task
+// refers to it.
+func LazyValue(kind Kind, fn func() interface{}) Value {
+	return Value{Kind: kind, lazy: &lazyValue{fn: fn}}
+}
+
+// ValueOf returns v as a Value of the matching kind. Values are kept as they
+// are, integers and floats are numbers, types without a kind of their own are
+// objects.
+func ValueOf(v interface{}) Value {
+	switch v := v.(type) {
+	case Value:
+		return v
+	case string:
+		return StringValue(v)
+	case bool:
+		return BoolValue(v)
+	case json.RawMessage:
+		return JSONValue(v)
+	case []byte:
+		return BytesValue(v)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return NumberValue(f)
+		}
+		return StringValue(v.String())
+	case int:
+		return NumberValue(float64(v))
+	case int32:
+		return NumberValue(float64(v))
+	case int64:
+		return NumberValue(float64(v))
+	case uint:
+		return NumberValue(float64(v))
+	case uint32:
+		return NumberValue(float64(v))
+	case uint64:
+		return NumberValue(float64(v))
+	case float32:
+		return NumberValue(float64(v))
+	case float64:
+		return NumberValue(v)
+	}
+	return ObjectValue(v)
+}
+
+// Interface returns the Go value: a string, float64, bool, []byte,
+// json.RawMessage or, for objects, whatever was stored.
+func (v Value) Interface() interface{} {
+	if v.lazy != nil {
+		return v.lazy.value()
+	}
+	return v.v
+}
+
+// String returns the text of the value as it is interpolated into the
+// properties of later tasks.
+func (v Value) String() string {
+	switch x := v.Interface().(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case json.RawMessage:
+		return string(x)
+	case []byte:
+		return string(x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// JSON returns the value as a JSON document, objects that cannot be
+// marshalled are turned into their text.
+func (v Value) JSON() json.RawMessage {
+	x := v.Interface()
+	if v.Kind == KindJSON {
+		if doc, ok := x.(json.RawMessage); ok && len(doc) > 0 {
+			return doc
+		}
+		return json.RawMessage("null")
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(x))
+	}
+	return b
+}
+
+// State holds the values of a job, keyed <Title>.<Output> for the outputs of
+// its tasks. It is safe for concurrent use.
+type State struct {
+	mu     sync.RWMutex
+	values map[string]Value
+}
+
+func NewState() *State {
+	return &State{values: make(map[string]Value)}
+}
+
+// Set stores v under key, stamping it with the current time unless it
+// carries one.
+func (s *State) Set(key string, v Value) {
+	if v.Time.IsZero() {
+		v.Time = time.Now()
+	}
+	s.mu.Lock()
+	s.values[key] = v
+	s.mu.Unlock()
+}
+
+// Get returns the value stored under key.
+func (s *State) Get(key string) (v Value, ok bool) {
+	s.mu.RLock()
+	v, ok = s.values[key]
+	s.mu.RUnlock()
+	return
+}
+
+// Keys returns the sorted keys starting with prefix.
+func (s *State) Keys(prefix string) (keys []string) {
+	s.mu.RLock()
+	for k := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	return
+}
+
+// Outputs returns the values stored under <title>.<name>, keyed by name.
+func (s *State) Outputs(title string) map[string]Value {
+	prefix := title + "."
+	outputs := make(map[string]Value)
+	s.mu.RLock()
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			outputs[k[len(prefix):]] = v
+		}
+	}
+	s.mu.RUnlock()
+	return outputs
+}
+
+// Copy returns a new state holding the values whose key keep returns true
+// for, every value when keep is nil. Lazy values are shared with s.
+func (s *State) Copy(keep func(key string) bool) *State {
+	c := NewState()
+	s.mu.RLock()
+	for k, v := range s.values {
+		if keep == nil || keep(k) {
+			c.values[k] = v
+		}
+	}
+	s.mu.RUnlock()
+	return c
+}
+
+// String lists the values, lazy values not computed yet are shown as <lazy>
+// so that logging a job does not consume a request body.
+func (s *State) String() string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, k := range s.Keys("") {
+		v, _ := s.Get(k)
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		text := "<lazy>"
+		if v.lazy == nil || v.lazy.computed() {
+			text = v.String()
+		}
+		fmt.Fprintf(&b, "%s: %s", k, text)
+	}
+	b.WriteString("}")
+	return b.String()
+}
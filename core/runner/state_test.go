@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLazyValue(t *testing.T) {
+	var calls int
+	s := NewState()
+	s.Set("In.Body", LazyValue(KindString, func() interface{} {
+		calls++
+		return "body"
+	}))
+
+	// logging a state does not read the body
+	if got := s.String(); got != "{In.Body: <lazy>}" {
+		t.Errorf("String = %s, want {In.Body: <lazy>}", got)
+	}
+	if calls != 0 {
+		t.Fatalf("String computed the lazy value %d times", calls)
+	}
+
+	c := s.Copy(nil)
+	v, _ := c.Get("In.Body")
+	if v.String() != "body" {
+		t.Errorf("copied value = %q, want body", v.String())
+	}
+	v, _ = s.Get("In.Body")
+	if v.String() != "body" {
+		t.Errorf("value = %q, want body", v.String())
+	}
+	if calls != 1 {
+		t.Errorf("lazy value computed %d times, want once for the state and its copy", calls)
+	}
+	if got := s.String(); got != "{In.Body: body}" {
+		t.Errorf("String = %s, want {In.Body: body}", got)
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		kind Kind
+		text string
+	}{
+		{"text", KindString, "text"},
+		{true, KindBool, "true"},
+		{3, KindNumber, "3"},
+		{int64(-4), KindNumber, "-4"},
+		{uint32(5), KindNumber, "5"},
+		{float32(0.5), KindNumber, "0.5"},
+		{1.25, KindNumber, "1.25"},
+		{json.Number("42"), KindNumber, "42"},
+		{json.Number("1e400"), KindString, "1e400"},
+		{[]byte("raw"), KindBytes, "raw"},
+		{json.RawMessage(`{"a":1}`), KindJSON, `{"a":1}`},
+		{StringValue("kept"), KindString, "kept"},
+		{struct{ A int }{1}, KindObject, "{1}"},
+		{nil, KindObject, ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%T", tt.v), func(t *testing.T) {
+			v := ValueOf(tt.v)
+			if v.Kind != tt.kind || v.String() != tt.text {
+				t.Errorf("ValueOf(%#v) = %s %q, want %s %q", tt.v, v.Kind, v.String(), tt.kind, tt.text)
+			}
+		})
+	}
+}
+
+// noJSON is an object that cannot be marshalled.
+type noJSON struct{}
+
+func (noJSON) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("no json")
+}
+
+func (noJSON) String() string {
+	return "no json"
+}
+
+func TestValueJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		v    Value
+		want string
+	}{
+		{"string", StringValue(`say "hi"`), `"say \"hi\""`},
+		{"number", NumberValue(2.5), `2.5`},
+		{"bool", BoolValue(false), `false`},
+		{"bytes", BytesValue([]byte("hi")), `"aGk="`},
+		{"json", JSONValue(json.RawMessage(`[1,2]`)), `[1,2]`},
+		{"empty json", JSONValue(nil), `null`},
+		{"object", ObjectValue(map[string]int{"a": 1}), `{"a":1}`},
+		{"unmarshallable object", ObjectValue(noJSON{}), `"no json"`},
+		{"lazy", LazyValue(KindNumber, func() interface{} { return 7.0 }), `7`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.v.JSON()); got != tt.want {
+				t.Errorf("JSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateConcurrentUse(t *testing.T) {
+	s := NewState()
+	s.Set("In.Body", LazyValue(KindString, func() interface{} { return "body" }))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("T%d.Out", i)
+				s.Set(key, NumberValue(float64(j)))
+				if _, ok := s.Get(key); !ok {
+					t.Errorf("Get(%s) found nothing", key)
+					return
+				}
+				c := s.Copy(func(k string) bool { return k == "In.Body" })
+				if v, _ := c.Get("In.Body"); v.String() != "body" {
+					t.Errorf("copied In.Body = %q, want body", v.String())
+					return
+				}
+				_ = s.String()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if keys := s.Keys("T"); len(keys) != 8 {
+		t.Errorf("Keys = %q, want 8 task outputs", keys)
+	}
+}
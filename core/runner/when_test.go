@@ -0,0 +1,57 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Kozical/taskengine/core"
+)
+
+func TestWhen(t *testing.T) {
+	p := new(stepProvider)
+	task := func(step, when string) Task {
+		return Task{Title: step, Properties: json.RawMessage(`{"Step":"` + step + `"}`), Provider: p, Options: core.TaskOptions{When: core.String(when)}}
+	}
+	j := newTestJob(nil,
+		task("always", ""),
+		task("post", "$(In.Method) == POST"),
+		task("get", "$(In.Method) == GET"),
+		task("found", "$(Latest.Result)"),
+		task("missing", "$(Missing.Output)"),
+		task("skipped-ran", "$(get.Attempts) || $(post.Attempts) == 1"),
+		task("count", "$(Latest.Result | len) > 1"),
+	)
+	j.Store("In.Method", "POST")
+	j.Store("Latest.Result", json.RawMessage(`[{"name": "a"}, {"name": "b"}]`))
+	if failed, err := j.runTasks(context.Background(), j.Tasks); err != nil {
+		t.Fatalf("%s: %v", failed, err)
+	}
+	if want := []string{"always", "post", "found", "skipped-ran", "count"}; !reflect.DeepEqual(p.steps, want) {
+		t.Errorf("steps = %q, want %q", p.steps, want)
+	}
+	skipped := make(map[string]bool)
+	for _, run := range j.History() {
+		if run.Err != nil {
+			t.Errorf("%s: %v", run.Task, run.Err)
+		}
+		skipped[run.Task] = run.Skipped
+	}
+	if want := map[string]bool{"always": false, "post": false, "get": true, "found": false, "missing": true, "skipped-ran": false, "count": false}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
+
+func TestWhenError(t *testing.T) {
+	p := new(stepProvider)
+	j := newTestJob(nil, Task{Title: "Bad", Properties: json.RawMessage(`{}`), Provider: p, Options: core.TaskOptions{When: "$(In.Body | nosuchfilter)"}})
+	j.Store("In.Body", "x")
+	failed, err := j.runTasks(context.Background(), j.Tasks)
+	if err == nil || failed != "Bad" || len(p.steps) > 0 {
+		t.Errorf("runTasks = %s, %v after %d steps, want Bad to fail without running", failed, err, len(p.steps))
+	}
+	if h := j.History(); len(h) != 1 || h[0].Skipped || h[0].Err == nil {
+		t.Errorf("history = %+v, want one failed run", h)
+	}
+}
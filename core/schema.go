@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PropertyType is the shape of value a provider property accepts.
+type PropertyType int
+
+const (
+	TypeAny PropertyType = iota
+	TypeString
+	TypeArray
+	TypeMap
+	TypeBool
+	TypeInt
+	TypeNumber
+	TypeDuration
+)
+
+func (t PropertyType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeArray:
+		return "array"
+	case TypeMap:
+		return "map"
+	case TypeBool:
+		return "bool"
+	case TypeInt:
+		return "int"
+	case TypeNumber:
+		return "number"
+	case TypeDuration:
+		return "duration"
+	}
+	return "any"
+}
+
+// MarshalJSON writes the type by name, as schemas are exchanged with plugins.
+func (t PropertyType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *PropertyType) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for v := TypeAny; v <= TypeDuration; v++ {
+		if v.String() == name {
+			*t = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown property type %q", name)
+}
+
+// PropertySchema describes a single property of a provider.
+type PropertySchema struct {
+	Name        string       `json:"name"`
+	Type        PropertyType `json:"type"`
+	Required    bool         `json:"required,omitempty"`
+	Enum        []string     `json:"enum,omitempty"`
+	Description string       `json:"description,omitempty"`
+}
+
+// Schema is published by every provider so jobs can be checked before they
+// are dispatched. Outputs lists the state values the provider stores under
+// <title>.<output>, an output ending in * matches any suffix.
+type Schema struct {
+	Properties []PropertySchema `json:"properties"`
+	Outputs    []string         `json:"outputs,omitempty"`
+}
+
+// Property returns the schema of the property called name.
+func (s Schema) Property(name string) (PropertySchema, bool) {
+	for _, p := range s.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return PropertySchema{}, false
+}
+
+// HasOutput reports whether the provider publishes the output name.
+func (s Schema) HasOutput(name string) bool {
+	for _, o := range s.Outputs {
+		if o == name {
+			return true
+		}
+		if strings.HasSuffix(o, "*") && strings.HasPrefix(name, o[:len(o)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types below are meant for provider Settings fields. Values reach the
+// providers as JSON typed by the job file, but a $(Task.Output) reference is
+// always interpolated into a string, so each of them also accepts its value
+// written as a string.
+
+// String accepts any JSON scalar, numbers and booleans keep the text they were
+// written with. null leaves it empty.
+type String string
+
+func (s *String) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		*s = ""
+	case len(b) > 0 && b[0] == '"':
+		var v string
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*s = String(v)
+	case len(b) > 0 && (b[0] == '[' || b[0] == '{'):
+		return fmt.Errorf("cannot use %s as a string", b)
+	default:
+		*s = String(b)
+	}
+	return nil
+}
+
+func (s String) String() string {
+	return string(s)
+}
+
+// Strings converts a String slice for APIs that take a []string.
+func Strings(s []String) []string {
+	out := make([]string, len(s))
+	for i, v := range s {
+		out[i] = string(v)
+	}
+	return out
+}
+
+// Int accepts an integer or a string holding one.
+type Int int
+
+func (i *Int) UnmarshalJSON(b []byte) error {
+	text, err := scalarText(b)
+	if err != nil || len(text) == 0 {
+		return err
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", text)
+	}
+	*i = Int(v)
+	return nil
+}
+
+// Duration accepts a duration string such as 15s, 5m or 1h30m, or a number
+// of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	text, err := scalarText(b)
+	if err != nil || len(text) == 0 {
+		return err
+	}
+	v, err := ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// ParseDuration parses a duration as written in a job file, plain numbers are
+// seconds.
+func ParseDuration(text string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", text)
+	}
+	return d, nil
+}
+
+// scalarText returns the text of a JSON number or string, null gives "".
+func scalarText(b []byte) (string, error) {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		return "", nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		err := json.Unmarshal(b, &s)
+		return strings.TrimSpace(s), err
+	}
+	if len(b) > 0 && (b[0] == '-' || (b[0] >= '0' && b[0] <= '9')) {
+		return string(b), nil
+	}
+	return "", fmt.Errorf("cannot use %s as a number", b)
+}
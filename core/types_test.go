@@ -0,0 +1,56 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTypedSettings(t *testing.T) {
+	type settings struct {
+		Name  String
+		Limit Int
+		Every Duration
+	}
+	tests := []struct {
+		json string
+		want settings
+		err  bool
+	}{
+		{`{"Name": "nodes", "Limit": 10, "Every": "15s"}`, settings{"nodes", 10, Duration(15 * time.Second)}, false},
+		{`{"Name": 42, "Limit": "10", "Every": 90}`, settings{"42", 10, Duration(90 * time.Second)}, false},
+		{`{"Name": true, "Every": 1.5}`, settings{Name: "true", Every: Duration(1500 * time.Millisecond)}, false},
+		{`{"Name": 1e3, "Every": "1h30m"}`, settings{Name: "1e3", Every: Duration(90 * time.Minute)}, false},
+		{`{"Name": null, "Limit": null, "Every": null}`, settings{}, false},
+		{`{"Limit": " 7 "}`, settings{Limit: 7}, false},
+		{`{"Name": ["a"]}`, settings{}, true},
+		{`{"Limit": 1.5}`, settings{}, true},
+		{`{"Limit": true}`, settings{}, true},
+		{`{"Every": "soon"}`, settings{}, true},
+		{`{"Every": {}}`, settings{}, true},
+	}
+	for _, tt := range tests {
+		var got settings
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: decoded %+v, want an error", tt.json, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: decoded %+v, %v, want %+v", tt.json, got, err, tt.want)
+		}
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	b, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil || string(b) != `"1m30s"` {
+		t.Errorf("Marshal = %s, %v, want \"1m30s\"", b, err)
+	}
+	var d Duration
+	if err = json.Unmarshal(b, &d); err != nil || d != Duration(90*time.Second) {
+		t.Errorf("Unmarshal(%s) = %s, %v", b, d, err)
+	}
+}
@@ -1,6 +1,7 @@
 package listener
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,29 +9,89 @@ import (
 	"log"
 	"net/http"
 	"os"
-	//	"reflect"
-	//	"sync"
+	"sync"
 
+	"github.com/Kozical/taskengine/core"
 	"github.com/Kozical/taskengine/core/runner"
 )
 
+// Version of the listener provider, listed by the runner.
+const Version = "1.0.0"
+
+// Schema describes the properties accepted by the listener provider
+var Schema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "Method", Type: core.TypeString, Required: true, Enum: []string{"Listen", "Respond"}},
+		{Name: "Path", Type: core.TypeString, Description: "URL path to listen on, required for Listen"},
+		{Name: "Headers", Type: core.TypeMap, Description: "headers added to the response"},
+		{Name: "Response", Type: core.TypeString, Description: "response body written by Respond"},
+	},
+	Outputs: []string{"W", "R", "Closer", "Body", "Method", "URL.*"},
+}
+
+// routes holds the handlers of the dispatched Listen tasks. Unlike
+// http.HandleFunc, routes can be removed again when a job is withdrawn, the
+// mux is rebuilt on every change.
+var routes = struct {
+	sync.RWMutex
+	handlers map[string]http.HandlerFunc
+	mux      *http.ServeMux
+}{
+	handlers: make(map[string]http.HandlerFunc),
+	mux:      http.NewServeMux(),
+}
+
+func addRoute(path string, handler http.HandlerFunc) error {
+	routes.Lock()
+	defer routes.Unlock()
+	if _, ok := routes.handlers[path]; ok {
+		return fmt.Errorf("path %s is already handled by another job", path)
+	}
+	routes.handlers[path] = handler
+	rebuildRoutes()
+	return nil
+}
+
+func removeRoute(path string) {
+	routes.Lock()
+	defer routes.Unlock()
+	delete(routes.handlers, path)
+	rebuildRoutes()
+}
+
+// rebuildRoutes must be called with routes locked.
+func rebuildRoutes() {
+	mux := http.NewServeMux()
+	for path, handler := range routes.handlers {
+		mux.HandleFunc(path, handler)
+	}
+	routes.mux = mux
+}
+
+func serveRoute(w http.ResponseWriter, r *http.Request) {
+	routes.RLock()
+	mux := routes.mux
+	routes.RUnlock()
+	mux.ServeHTTP(w, r)
+}
+
+// Settings are the properties of a listener task.
+type Settings struct {
+	Method   core.String            `json:"Method"`
+	Path     core.String            `json:"Path"`
+	Headers  map[string]interface{} `json:"Headers"`
+	Response core.String            `json:"Response"`
+}
+
 // ListenerProvider: Implements the core.Provider interface
 type ListenerProvider struct {
-	Title      string
-	Properties map[string]string
-	Config     struct {
+	Config struct {
 		BindAddress string `json:"bind_addr"`
 		BindPort    int    `json:"bind_port"`
 		UseTLS      bool   `json:"use_tls"`
 		KeyPath     string `json:"key_path"`
 		CrtPath     string `json:"crt_path"`
 	}
-	Settings struct {
-		Method   string            `json:"Method"`
-		Path     string            `json:"Path"`
-		Headers  map[string]string `json:"Headers"`
-		Response string            `json:"Response"`
-	}
 }
 
 func NewListenerProvider(path string) (lp *ListenerProvider, err error) {
@@ -53,13 +114,23 @@ func NewListenerProvider(path string) (lp *ListenerProvider, err error) {
 }
 
 func (lp *ListenerProvider) String() string {
-	return fmt.Sprintf("ListenerProvider{Title: %s, Properties: %v}\n", lp.Title, lp.Properties)
+	return fmt.Sprintf("ListenerProvider{BindAddress: %s, BindPort: %d}\n", lp.Config.BindAddress, lp.Config.BindPort)
 }
 
 func (lp *ListenerProvider) Execute(j *runner.Job) error {
-	switch lp.Settings.Method {
+	return lp.ExecuteContext(j.Context(), j, j.Task())
+}
+
+// ExecuteContext writes the response of a Respond task, Listen tasks have
+// nothing left to do once their request has started the job.
+func (lp *ListenerProvider) ExecuteContext(ctx context.Context, j *runner.Job, task *runner.Task) error {
+	settings, err := settingsOf(j, task)
+	if err != nil {
+		return err
+	}
+	switch settings.Method {
 	case "Respond":
-		return lp.Respond(j)
+		return respond(j, task, settings)
 	case "Listen":
 		return nil
 	default:
@@ -67,110 +138,124 @@ func (lp *ListenerProvider) Execute(j *runner.Job) error {
 	}
 }
 
-func (lp *ListenerProvider) Register(fn func() *runner.Job) {
-	job := fn()
-
-	var task *runner.Task
-	for _, t := range job.Tasks {
-		if t.Provider == lp {
-			task = &t
-			break
-		}
+// Register serves the Path of a Listen task. The runner registers every
+// listener task, Respond tasks are left alone: their properties refer to the
+// outputs of a request, so Method and Path are read without interpolation.
+func (lp *ListenerProvider) Register(ctx context.Context, task *runner.Task, fn func() *runner.Job) {
+	var settings Settings
+	if err := json.Unmarshal(task.Properties, &settings); err != nil {
+		log.Printf("ListenerProvider.Register() Failed to unmarshal ListenerProvider Properties -> %v\n", err)
+		return
+	}
+	if settings.Method == "Listen" {
+		listen(ctx, task, settings, fn)
 	}
+}
+
+// settingsOf reads the settings of task in j.
+func settingsOf(j *runner.Job, task *runner.Task) (settings Settings, err error) {
 	if task == nil {
-		log.Printf("ListenerProvider.Register() task was nil")
+		err = errors.New("ListenerProvider task was nil")
 		return
 	}
-	err := json.Unmarshal(task.Properties, &lp.Settings)
+	err = j.Decode(task, &settings)
 	if err != nil {
-		log.Printf("Failed to unmarshal ListenerProvider Properties\n")
+		err = fmt.Errorf("Failed to unmarshal ListenerProvider Properties -> %v", err)
 		return
 	}
-	if len(lp.Settings.Method) == 0 {
-		log.Printf("Method parameter must be provided to Listener Provider!\n")
-		return
+	if len(settings.Method) == 0 {
+		err = errors.New("Method parameter must be provided to Listener Provider!")
 	}
-	lp.Properties = make(map[string]string)
-
-	for _, name := range []string{"W", "R", "Closer", "Body", "Method"} {
-		lp.Properties[name] = fmt.Sprintf("%s.%s", task.Title, name)
-	}
-
-	lp.Title = task.Title
-
-	switch lp.Settings.Method {
-	case "Listen":
-		lp.RegisterListen(task, fn)
-	case "Respond":
-		lp.RegisterRespond(task, fn)
-	default:
-		return
-	}
-}
-
-func (lp *ListenerProvider) RegisterRespond(t *runner.Task, fn func() *runner.Job) {
-
+	return
 }
 
-func (lp *ListenerProvider) RegisterListen(t *runner.Task, fn func() *runner.Job) {
-	if len(lp.Settings.Path) == 0 {
+// listen serves Path until ctx is cancelled, every request runs a fresh job.
+// The outputs of the request are stored under the title of the task, so the
+// Respond task answering it must have the same title.
+func listen(ctx context.Context, task *runner.Task, settings Settings, fn func() *runner.Job) {
+	if len(settings.Path) == 0 {
 		log.Printf("Path parameter not provided to Listener Provider!")
 		return
 	}
-	http.HandleFunc(lp.Settings.Path, func(w http.ResponseWriter, r *http.Request) {
+	err := addRoute(settings.Path.String(), func(w http.ResponseWriter, r *http.Request) {
 		j := fn()
-		closer := make(chan struct{}, 0)
-		for k, _ := range r.URL.Query() {
-			j.Store(fmt.Sprintf("%s.URL.%s", lp.Title, k), func() interface{} {
-				key := k
-				return j.State[lp.Properties["R"]]().(*http.Request).URL.Query()[key][0]
-			})
+		closer := make(chan struct{}, 1)
+		query := r.URL.Query()
+		for k := range query {
+			j.Publish(task, "URL."+k, query[k][0])
 		}
 
-		j.Store(lp.Properties["W"], func() interface{} { return w })
-		j.Store(lp.Properties["R"], func() interface{} { return r })
-		j.Store(lp.Properties["Body"], func() interface{} {
+		j.Publish(task, "W", w)
+		j.Publish(task, "R", r)
+		j.Publish(task, "Body", runner.LazyValue(runner.KindString, func() interface{} {
 			body, err := ioutil.ReadAll(r.Body)
 			if err != nil {
-				return nil
+				return ""
 			}
 			r.Body.Close()
 			return string(body)
-		})
-		j.Store(lp.Properties["Method"], func() interface{} { return r.Method })
-		j.Store(lp.Properties["Closer"], func() interface{} {
+		}))
+		j.Publish(task, "Method", r.Method)
+		j.Publish(task, "Closer", runner.LazyValue(runner.KindObject, func() interface{} {
 			closer <- struct{}{}
 			return nil
-		})
+		}))
 
 		j.Run()
-		<-closer
+		select {
+		case <-closer:
+		case <-j.Done():
+			// the run ended without responding, unless it did so last
+			select {
+			case <-closer:
+			default:
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		case <-ctx.Done():
+			http.Error(w, "job withdrawn", http.StatusServiceUnavailable)
+		}
 	})
+	if err != nil {
+		log.Printf("ListenerProvider.Register() %s -> %v\n", task.Title, err)
+		return
+	}
+	<-ctx.Done()
+	removeRoute(settings.Path.String())
 }
 
-func (lp *ListenerProvider) Respond(j *runner.Job) (err error) {
-	w := j.State[lp.Properties["W"]]().(http.ResponseWriter)
-
-	response := j.InterpolateState(lp.Settings.Response)
+func respond(j *runner.Job, task *runner.Task, settings Settings) (err error) {
+	v, ok := j.Load(task.Title + ".W")
+	if !ok {
+		return fmt.Errorf("%s has no request to respond to", task.Title)
+	}
+	w := v.(http.ResponseWriter)
 
-	for k, v := range lp.Settings.Headers {
-		w.Header().Add(k, v)
+	for k, v := range settings.Headers {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, hv := range v {
+				w.Header().Add(k, fmt.Sprint(hv))
+			}
+		default:
+			w.Header().Add(k, fmt.Sprint(v))
+		}
 	}
 
-	_, err = w.Write(response)
+	// Decode interpolated the response, what a client sent is not expanded again
+	_, err = w.Write([]byte(settings.Response))
 
-	j.State[lp.Properties["Closer"]]()
+	j.Load(task.Title + ".Closer")
 	return
 }
 
 func (lp *ListenerProvider) Listen() {
 	if lp.Config.UseTLS {
-		err := http.ListenAndServeTLS(fmt.Sprintf("%s:%d", lp.Config.BindAddress, lp.Config.BindPort), lp.Config.CrtPath, lp.Config.KeyPath, nil)
+		err := http.ListenAndServeTLS(fmt.Sprintf("%s:%d", lp.Config.BindAddress, lp.Config.BindPort), lp.Config.CrtPath, lp.Config.KeyPath, http.HandlerFunc(serveRoute))
 		if err != nil {
 			panic(fmt.Errorf("ListenAndServeTLS failed -> %v\n", err))
 		}
 	} else {
-		err := http.ListenAndServe(fmt.Sprintf("%s:%d", lp.Config.BindAddress, lp.Config.BindPort), nil)
+		err := http.ListenAndServe(fmt.Sprintf("%s:%d", lp.Config.BindAddress, lp.Config.BindPort), http.HandlerFunc(serveRoute))
 		if err != nil {
 			panic(fmt.Errorf("ListenAndServe failed -> %v\n", err))
 		}
@@ -0,0 +1,158 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+	"github.com/Kozical/taskengine/providers/localexec"
+)
+
+// waitForRoute waits until a Listen task serves path.
+func waitForRoute(t *testing.T, path string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		routes.RLock()
+		_, ok := routes.handlers[path]
+		routes.RUnlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("%s was never served", path)
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	const requests = 20
+	lp := new(ListenerProvider)
+	var h providertest.Harness
+	e := h.Register(context.Background(),
+		providertest.Task(lp, "In", Settings{Method: "Listen", Path: "/concurrent"}),
+		providertest.Task(localexec.NewLocalExecProvider(), "Echo", localexec.Settings{File: "echo", Args: []core.String{"-n", "$(In.Body)"}}),
+		providertest.Task(lp, "In", Settings{Method: "Respond", Response: "$(Echo.Stdout)"}),
+	)
+	defer e.Close()
+	waitForRoute(t, "/concurrent")
+	srv := httptest.NewServer(http.HandlerFunc(serveRoute))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("request-%d", i)
+			res, err := http.Post(srv.URL+"/concurrent", "text/plain", strings.NewReader(body))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer res.Body.Close()
+			b, _ := ioutil.ReadAll(res.Body)
+			if string(b) != body {
+				errs <- fmt.Errorf("response to %s = %q", body, b)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	states := make(map[*runner.State]bool)
+	bodies := make(map[string]bool)
+	for i := 0; i < requests; i++ {
+		j := e.NextWithin(5 * time.Second)
+		if j == nil {
+			t.Fatalf("only %d of %d requests recorded a job", i, requests)
+		}
+		if states[j.State] {
+			t.Errorf("job %d shares its state with another request", j.ID)
+		}
+		states[j.State] = true
+		body, _ := j.Load("Echo.Stdout")
+		if b, _ := body.(string); !strings.HasPrefix(b, "request-") || bodies[b] {
+			t.Errorf("job %d echoed %q", j.ID, body)
+		} else {
+			bodies[b] = true
+		}
+		var titles []string
+		for _, run := range j.History() {
+			if run.Err != nil {
+				t.Errorf("job %d: %s: %v", j.ID, run.Task, run.Err)
+			}
+			titles = append(titles, run.Task)
+		}
+		if want := []string{"In", "Echo", "In", providertest.DoneTask}; strings.Join(titles, ",") != strings.Join(want, ",") {
+			t.Errorf("job %d history = %q, want %q", j.ID, titles, want)
+		}
+	}
+}
+
+// secrets is a runner.SecretStore holding its secrets in memory.
+type secrets map[string]string
+
+func (s secrets) Secret(name string) (string, error) {
+	v, ok := s[name]
+	if !ok {
+		return "", runner.ErrSecretNotFound
+	}
+	return v, nil
+}
+
+func TestRespondDoesNotExpandRequest(t *testing.T) {
+	os.Setenv("TASKENGINE_TEST_HOME", "/home/test")
+	defer os.Unsetenv("TASKENGINE_TEST_HOME")
+	h := providertest.Harness{Secrets: secrets{"pw": "hunter2"}}
+	lp := new(ListenerProvider)
+	e := h.Register(context.Background(),
+		providertest.Task(lp, "In", Settings{Method: "Listen", Path: "/respond-body"}),
+		providertest.Task(lp, "In", Settings{Method: "Respond", Response: "got ${env:TASKENGINE_TEST_HOME}: $(In.Body)"}),
+	)
+	defer e.Close()
+
+	for _, body := range []string{"${secret:pw}", "${env:TASKENGINE_TEST_HOME}", "cost $(x)", "$(In.Method)", `"quoted" \ text`} {
+		rec := httptest.NewRecorder()
+		e.Fire(map[string]interface{}{"W": rec, "Body": body, "Method": "POST"})
+		j := e.NextWithin(5 * time.Second)
+		if j == nil {
+			t.Fatalf("%s: no job ran", body)
+		}
+		if err := providertest.Err(j); err != nil {
+			t.Errorf("%s: %v", body, err)
+		}
+		if want := "got /home/test: " + body; rec.Body.String() != want {
+			t.Errorf("response = %q, want %q", rec.Body.String(), want)
+		}
+	}
+}
+
+func TestRegisterRespond(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	jobs := 0
+	task := providertest.Task(new(ListenerProvider), "In", Settings{Method: "Respond", Response: "$(In.Body)"})
+	new(ListenerProvider).Register(context.Background(), &task, func() *runner.Job {
+		jobs++
+		return nil
+	})
+	if jobs > 0 || buf.Len() > 0 {
+		t.Errorf("registering a Respond task built %d jobs and logged %q", jobs, buf.String())
+	}
+}
@@ -2,29 +2,42 @@ package localexec
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os/exec"
 
+	"github.com/Kozical/taskengine/core"
 	"github.com/Kozical/taskengine/core/runner"
 )
 
-// LocalExecActionProvider: implements core.ActionProvider
-type LocalExecProvider struct {
-	Properties map[string]string
-	Settings   struct {
-		File string   `json:"File"`
-		Args []string `json:"Args"`
-	}
+// Version of the localexec provider, listed by the runner.
+const Version = "1.0.0"
+
+// Schema describes the properties accepted by the localexec provider
+var Schema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "File", Type: core.TypeString, Required: true},
+		{Name: "Args", Type: core.TypeArray, Required: true},
+	},
+	Outputs: []string{"Stdout", "Stderr"},
+}
+
+// Settings are the properties of a localexec task.
+type Settings struct {
+	File core.String   `json:"File"`
+	Args []core.String `json:"Args"`
 }
 
+// LocalExecActionProvider: implements core.ActionProvider
+type LocalExecProvider struct{}
+
 func NewLocalExecProvider() *LocalExecProvider {
 	return &LocalExecProvider{}
 }
 
 func (lp *LocalExecProvider) String() string {
-	return fmt.Sprintf("LocalExecProvider{Properties: %v}\n", lp.Properties)
+	return "LocalExecProvider{}"
 }
 
 /*
@@ -34,50 +47,51 @@ type Provider interface {
 */
 
 func (lp *LocalExecProvider) Execute(j *runner.Job) (err error) {
-	var task *runner.Task
-	for _, t := range j.Tasks {
-		if t.Provider == lp {
-			task = &t
-			break
-		}
-	}
+	return lp.ExecuteContext(j.Context(), j, j.Task())
+}
+
+// ExecuteContext runs File, killing the process when ctx is done.
+func (lp *LocalExecProvider) ExecuteContext(ctx context.Context, j *runner.Job, task *runner.Task) (err error) {
 	if task == nil {
 		err = errors.New("LocalExecProvider Task was nil")
 		return
 	}
 
-	properties := j.InterpolateState(string(task.Properties))
-
-	err = json.Unmarshal(properties, &lp.Settings)
+	var settings Settings
+	err = j.Decode(task, &settings)
 	if err != nil {
 		return
 	}
-	if len(lp.Settings.File) == 0 {
+	if len(settings.File) == 0 {
 		err = errors.New("File parameter not provided to LocalExec")
 		return
 	}
-	if len(lp.Settings.Args) == 0 {
+	if len(settings.Args) == 0 {
 		err = errors.New("Args parameter not provided to LocalExec")
 		return
 	}
-	lp.Properties = make(map[string]string)
-
-	for _, name := range []string{"Stdout", "Stderr"} {
-		lp.Properties[name] = fmt.Sprintf("%s.%s", task.Title, name)
-	}
 
 	var stderr, stdout bytes.Buffer
-	cmd := exec.Command(lp.Settings.File, lp.Settings.Args...)
+	cmd := exec.CommandContext(ctx, settings.File.String(), core.Strings(settings.Args)...)
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
 
 	err = cmd.Run()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("Error executing %s -> %v\n", settings.File, ctx.Err())
+		return
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		// a non-zero exit status, retry policies can match it with on: exit
+		err = runner.ClassifyError("exit", fmt.Errorf("Error executing %s -> %v\n", settings.File, err))
+		return
+	}
 	if err != nil {
-		err = fmt.Errorf("Error executing %s -> %v\n", lp.Settings.File, err)
+		err = fmt.Errorf("Error executing %s -> %v\n", settings.File, err)
 		return
 	}
 
-	j.State[lp.Properties["Stdout"]] = func() interface{} { return stdout.String() }
-	j.State[lp.Properties["Stderr"]] = func() interface{} { return stderr.String() }
+	j.Publish(task, "Stdout", stdout.String())
+	j.Publish(task, "Stderr", stderr.String())
 	return
 }
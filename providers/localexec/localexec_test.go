@@ -0,0 +1,45 @@
+package localexec
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+)
+
+func TestExecute(t *testing.T) {
+	var h providertest.Harness
+	task := providertest.Task(NewLocalExecProvider(), "Echo", Settings{File: "echo", Args: []core.String{"hello"}})
+	j, err := h.Execute(context.Background(), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := j.Load("Echo.Stdout"); out != "hello\n" {
+		t.Errorf("Echo.Stdout = %q, want hello", out)
+	}
+}
+
+func TestExecuteContextCancel(t *testing.T) {
+	var h providertest.Harness
+	task := providertest.Task(NewLocalExecProvider(), "Sleep", Settings{File: "sleep", Args: []core.String{"10"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Execute(ctx, task)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "context canceled") {
+			t.Errorf("error = %v, want the cancellation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the process was not killed when its task was cancelled")
+	}
+}
@@ -1,6 +1,7 @@
 package mongo
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -9,14 +10,30 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
-	"strconv"
+	"time"
 
+	"github.com/Kozical/taskengine/core"
 	"github.com/Kozical/taskengine/core/runner"
 	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-var session *mgo.Session
+// Version of the mongo provider, listed by the runner.
+const Version = "1.0.0"
+
+// Schema describes the properties accepted by the mongo provider
+var Schema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "Database", Type: core.TypeString, Required: true},
+		{Name: "Collection", Type: core.TypeString, Required: true},
+		{Name: "Query", Type: core.TypeMap},
+		{Name: "Pipeline", Type: core.TypeArray, Description: "aggregation pipeline, Query, Limit and Sort are ignored when set"},
+		{Name: "Limit", Type: core.TypeInt},
+		{Name: "Sort", Type: core.TypeString},
+		{Name: "ObjectId", Type: core.TypeString},
+	},
+	Outputs: []string{"Result"},
+}
 
 /*
 type Provider interface {
@@ -28,9 +45,19 @@ type Provider interface {
 }
 */
 
+// Settings are the properties of a mongo task.
+type Settings struct {
+	Database   core.String            `json:"Database"`
+	Collection core.String            `json:"Collection"`
+	Query      map[string]interface{} `json:"Query"`
+	Pipeline   []interface{}          `json:"Pipeline"`
+	Limit      core.Int               `json:"Limit"`
+	Sort       core.String            `json:"Sort"`
+	ObjectID   core.String            `json:"ObjectId"`
+}
+
 type MongoProvider struct {
-	Properties map[string]string
-	Config     struct {
+	Config struct {
 		Addrs          []string `json:"addrs"`
 		Port           int      `json:"port"`
 		User           string   `json:"user"`
@@ -39,17 +66,27 @@ type MongoProvider struct {
 		UseInsecureTLS bool     `json:"use_insecure_tls"`
 		CAPath         string   `json:"ca_path"`
 	}
-	Settings struct {
-		Database   string            `json:"Database"`
-		Collection string            `json:"Collection"`
-		Query      map[string]string `json:"Query"`
-		Limit      string            `json:"Limit"`
-		Sort       string            `json:"Sort"`
-		ObjectID   string            `json:"ObjectId"`
-	}
+
+	info mgo.DialInfo
+	// session is dialled once, every task runs on a copy of it
+	session *mgo.Session
 }
 
-func NewMongoProvider(path string) (mp *MongoProvider, err error) {
+func (mp *MongoProvider) String() string {
+	return "MongoProvider{}"
+}
+
+const (
+	// dialTimeout bounds connecting to the servers
+	dialTimeout = 10 * time.Second
+	// socketTimeout bounds a query of a task without a deadline
+	socketTimeout = time.Minute
+)
+
+// NewMongoProvider reads the configuration at path and connects to the
+// server. The user and pass settings may be ${env:NAME} or ${secret:name}
+// references.
+func NewMongoProvider(path string, secrets runner.SecretStore) (mp *MongoProvider, err error) {
 	mp = new(MongoProvider)
 	var f *os.File
 	f, err = os.Open(path)
@@ -64,12 +101,21 @@ func NewMongoProvider(path string) (mp *MongoProvider, err error) {
 	if err != nil {
 		return
 	}
+	mp.Config.User, err = runner.ResolveReferences(mp.Config.User, secrets)
+	if err != nil {
+		return
+	}
+	mp.Config.Pass, err = runner.ResolveReferences(mp.Config.Pass, secrets)
+	if err != nil {
+		return
+	}
 
-	info := &mgo.DialInfo{
+	mp.info = mgo.DialInfo{
 		Addrs:    mp.Config.Addrs,
 		Username: mp.Config.User,
 		Password: mp.Config.Pass,
 		FailFast: true,
+		Timeout:  dialTimeout,
 	}
 
 	if mp.Config.UseTLS {
@@ -94,66 +140,109 @@ func NewMongoProvider(path string) (mp *MongoProvider, err error) {
 				RootCAs: pool,
 			}
 		}
-		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
-			return tls.Dial("tcp", addr.String(), tlsConfig)
+		mp.info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			d := &net.Dialer{Timeout: dialTimeout}
+			return tls.DialWithDialer(d, "tcp", addr.String(), tlsConfig)
 		}
 	}
-	session, err = mgo.DialWithInfo(info)
+	err = mp.connect()
 	return
 }
 
-func (mp *MongoProvider) Execute(j *runner.Job) (err error) {
-	var task *runner.Task
-	for _, t := range j.Tasks {
-		if t.Provider == mp {
-			task = &t
-			break
-		}
+// connect dials the session shared by the tasks. Queries may take longer
+// than connecting, their sockets get a timeout of their own.
+func (mp *MongoProvider) connect() (err error) {
+	mp.session, err = mgo.DialWithInfo(&mp.info)
+	if err != nil {
+		return
 	}
+	mp.session.SetSocketTimeout(socketTimeout)
+	return
+}
+
+func (mp *MongoProvider) Execute(j *runner.Job) (err error) {
+	return mp.ExecuteContext(j.Context(), j, j.Task())
+}
+
+// ExecuteContext runs the query on a copy of the shared session, bounded by
+// the deadline of ctx. When ctx is done the task returns without waiting for
+// the server, the copy is closed once the query has ended.
+func (mp *MongoProvider) ExecuteContext(ctx context.Context, j *runner.Job, task *runner.Task) (err error) {
 	if task == nil {
 		err = errors.New("MongoProvider received a nil task")
 		return
 	}
 
-	mp.Properties = make(map[string]string)
+	var settings Settings
+	err = j.Decode(task, &settings)
+	if err != nil {
+		return
+	}
 
-	for _, name := range []string{"Result"} {
-		mp.Properties[name] = fmt.Sprintf("%s.%s", task.Title, name)
+	s := mp.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		// the socket outlives the deadline a little, ctx reports it first
+		if d := time.Until(deadline) + time.Second; d < socketTimeout {
+			s.SetSocketTimeout(d)
+		}
+	}
+	done := make(chan error, 1)
+	var result []bson.M
+	go func() {
+		defer s.Close()
+		done <- query(s, &settings, &result)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+	}
+	if err != nil {
+		return
+	}
+	return storeResult(j, task, result)
+}
+
+func query(s *mgo.Session, settings *Settings, result *[]bson.M) error {
+	c := s.DB(settings.Database.String()).C(settings.Collection.String())
+
+	if len(settings.Pipeline) > 0 {
+		return c.Pipe(settings.Pipeline).All(result)
 	}
 
 	var query interface{}
 
-	if len(mp.Settings.Query) == 0 {
+	if len(settings.Query) == 0 {
 		query = nil
-	} else if len(mp.Settings.ObjectID) > 0 {
-		query = bson.M{"_id": bson.ObjectIdHex(mp.Settings.ObjectID)}
+	} else if len(settings.ObjectID) > 0 {
+		if !bson.IsObjectIdHex(settings.ObjectID.String()) {
+			return fmt.Errorf("ObjectId %q is not a hex object id", settings.ObjectID)
+		}
+		query = bson.M{"_id": bson.ObjectIdHex(settings.ObjectID.String())}
 	} else {
-		query = mp.Settings.Query
+		query = settings.Query
 	}
 
-	q := session.DB(mp.Settings.Database).C(mp.Settings.Collection).Find(query)
+	q := c.Find(query)
 
-	if len(mp.Settings.Limit) > 0 {
-		var i int
-		i, err = strconv.Atoi(mp.Settings.Limit)
-		if err != nil {
-			return
-		}
-		q = q.Limit(i)
+	if settings.Limit > 0 {
+		q = q.Limit(int(settings.Limit))
 	}
 
-	if len(mp.Settings.Sort) > 0 {
-		q = q.Sort(mp.Settings.Sort)
+	if len(settings.Sort) > 0 {
+		q = q.Sort(settings.Sort.String())
 	}
 
-	var result []bson.M
-	q.All(&result)
+	return q.All(result)
+}
 
+func storeResult(j *runner.Job, task *runner.Task, result []bson.M) (err error) {
 	b, err := json.Marshal(&result)
 	if err != nil {
 		return
 	}
 
-	j.State[mp.Properties["Result"]] = func() interface{} { return string(b) }
+	j.Publish(task, "Result", json.RawMessage(b))
 	return
 }
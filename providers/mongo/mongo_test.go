@@ -0,0 +1,166 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// hangingServer answers the getnonce, isMaster and ping commands of mgo and never
+// answers anything else, as a server stuck on a slow query would. With
+// handshake unset it answers nothing at all.
+func hangingServer(t *testing.T, handshake bool) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveCommands(conn, handshake)
+		}
+	}()
+	return ln
+}
+
+func serveCommands(conn net.Conn, handshake bool) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, int(binary.LittleEndian.Uint32(header))-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		// OP_QUERY: flags, then the collection the query is sent to
+		const opQuery = 2004
+		collection := body[4 : 4+bytes.IndexByte(body[4:], 0)]
+		if !handshake || binary.LittleEndian.Uint32(header[12:]) != opQuery || !bytes.HasSuffix(collection, []byte(".$cmd")) {
+			continue
+		}
+		doc, _ := bson.Marshal(bson.M{"ok": 1, "ismaster": true, "maxWireVersion": 2, "nonce": "2375531c32080ae8"})
+		reply := make([]byte, 36, 36+len(doc))
+		binary.LittleEndian.PutUint32(reply[0:], uint32(36+len(doc)))
+		copy(reply[8:12], header[4:8])               // responseTo
+		binary.LittleEndian.PutUint32(reply[12:], 1) // OP_REPLY
+		binary.LittleEndian.PutUint32(reply[32:], 1) // numberReturned
+		if _, err := conn.Write(append(reply, doc...)); err != nil {
+			return
+		}
+	}
+}
+
+// testProvider returns a provider connected to the server at addr.
+func testProvider(t *testing.T, addr string) *MongoProvider {
+	t.Helper()
+	mp := &MongoProvider{info: mgo.DialInfo{Addrs: []string{addr}, Direct: true, FailFast: true, Timeout: time.Second}}
+	if err := mp.connect(); err != nil {
+		t.Fatal(err)
+	}
+	return mp
+}
+
+func TestConnectTimeout(t *testing.T) {
+	ln := hangingServer(t, false)
+	defer ln.Close()
+	mp := &MongoProvider{info: mgo.DialInfo{Addrs: []string{ln.Addr().String()}, Direct: true, FailFast: true, Timeout: 200 * time.Millisecond}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- mp.connect()
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("connected to a server that never answers")
+		}
+	case <-time.After(15 * time.Second):
+		// mgo waits for its 5s sync socket timeout as well, without a dial
+		// timeout it would keep waiting for the server
+		t.Fatal("connect did not stop at the dial timeout")
+	}
+}
+
+func TestExecuteContextCancel(t *testing.T) {
+	ln := hangingServer(t, true)
+	defer ln.Close()
+	mp := testProvider(t, ln.Addr().String())
+	defer mp.session.Close()
+
+	var h providertest.Harness
+	task := providertest.Task(mp, "Latest", Settings{Database: "razor", Collection: "nodes"})
+	j := h.Job(context.Background(), task)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- mp.ExecuteContext(ctx, j, &j.Tasks[0])
+	}()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteContext did not return after its context was cancelled")
+	}
+	if _, ok := j.Load("Latest.Result"); ok {
+		t.Error("a cancelled query stored a Result")
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	ln := hangingServer(t, true)
+	defer ln.Close()
+	mp := testProvider(t, ln.Addr().String())
+	defer mp.session.Close()
+
+	task := providertest.Task(mp, "Latest", Settings{Database: "razor", Collection: "nodes"})
+	task.Options.Timeout = core.Duration(100 * time.Millisecond)
+	var h providertest.Harness
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := h.Run(ctx, task)
+	if j == nil {
+		t.Fatal("the task did not stop at its timeout")
+	}
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("error = %v, want the timeout", err)
+	}
+}
+
+func TestInvalidObjectID(t *testing.T) {
+	ln := hangingServer(t, true)
+	defer ln.Close()
+	mp := testProvider(t, ln.Addr().String())
+	defer mp.session.Close()
+
+	var h providertest.Harness
+	task := providertest.Task(mp, "Node", Settings{
+		Database:   "razor",
+		Collection: "nodes",
+		Query:      map[string]interface{}{"name": "x"},
+		ObjectID:   "not-hex",
+	})
+	_, err := h.Execute(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), `ObjectId "not-hex" is not a hex object id`) {
+		t.Errorf("error = %v, want the invalid ObjectId", err)
+	}
+}
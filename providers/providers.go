@@ -1 +1,75 @@
 package providers
+
+import (
+	"os"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner"
+
+	"github.com/Kozical/taskengine/providers/listener"
+	"github.com/Kozical/taskengine/providers/localexec"
+	"github.com/Kozical/taskengine/providers/mongo"
+	"github.com/Kozical/taskengine/providers/ticker"
+)
+
+// Builtin describes the built-in providers, the aliases are the names used
+// for them in earlier versions. New is set by Register.
+var Builtin = []runner.ProviderInfo{
+	{Name: "listener", Aliases: []string{"listener_event", "listener_action"}, Version: listener.Version, Schema: listener.Schema},
+	{Name: "localexec", Aliases: []string{"localexec_action"}, Version: localexec.Version, Schema: localexec.Schema},
+	{Name: "mongo", Aliases: []string{"mongo_find_action"}, Version: mongo.Version, Schema: mongo.Schema},
+	{Name: "ticker", Aliases: []string{"ticker_event"}, Version: ticker.Version, Schema: ticker.Schema},
+}
+
+// Schemas returns the schema of every built-in provider keyed by the names
+// and aliases used for it in job files.
+func Schemas() map[string]core.Schema {
+	schemas := make(map[string]core.Schema)
+	for _, info := range Builtin {
+		schemas[info.Name] = info.Schema
+		for _, alias := range info.Aliases {
+			schemas[alias] = info.Schema
+		}
+	}
+	return schemas
+}
+
+// Config holds the paths of the configuration files of the built-in
+// providers.
+type Config struct {
+	ListenerPath string
+	MongoPath    string
+}
+
+// Register registers the built-in providers with r. The listener and mongo
+// providers are left out when their configuration file does not exist.
+func Register(r *runner.Runner, config Config) (err error) {
+	for _, info := range Builtin {
+		var p runner.Provider
+		switch info.Name {
+		case "listener":
+			if _, err = os.Stat(config.ListenerPath); err != nil {
+				continue
+			}
+			if p, err = listener.NewListenerProvider(config.ListenerPath); err != nil {
+				return
+			}
+		case "mongo":
+			if _, err = os.Stat(config.MongoPath); err != nil {
+				continue
+			}
+			if p, err = mongo.NewMongoProvider(config.MongoPath, r.Secrets); err != nil {
+				return
+			}
+		case "localexec":
+			p = localexec.NewLocalExecProvider()
+		case "ticker":
+			p = ticker.NewTickerProvider()
+		}
+		info.New = func() (runner.Provider, error) { return p, nil }
+		if err = r.Register(info); err != nil {
+			return
+		}
+	}
+	return nil
+}
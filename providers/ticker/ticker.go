@@ -1,17 +1,25 @@
 package ticker
 
 import (
-	"encoding/json"
+	"context"
 	"log"
-	"strconv"
-	"sync"
 	"time"
 
+	"github.com/Kozical/taskengine/core"
 	"github.com/Kozical/taskengine/core/runner"
 )
 
-var tickerLock sync.Mutex
-var tickers []*time.Ticker
+// Version of the ticker provider, listed by the runner.
+const Version = "1.0.0"
+
+// Schema describes the properties accepted by the ticker provider
+var Schema = core.Schema{
+	Properties: []core.PropertySchema{
+		{Name: "Every", Type: core.TypeDuration, Description: "time between runs, e.g. 15s or 5m"},
+		{Name: "Interval", Type: core.TypeInt, Description: "number of Periods between runs, used when Every is not set"},
+		{Name: "Period", Type: core.TypeString, Enum: []string{"Millisecond", "Second", "Minute", "Hour", "Day"}},
+	},
+}
 
 /*
 
@@ -20,21 +28,21 @@ type Provider interface {
 }
 
 type EventProvider interface {
-	Register(func() *Job)
+	Register(context.Context, *Task, func() *Job)
 }
 
 */
 
-// TickerProvider: implements core.Provider interface
-type TickerProvider struct {
-	Settings struct {
-		Interval string `json:"Interval"`
-		Period   string `json:"Period"`
-	}
-	interval int
-	period   int
+// Settings are the properties of a ticker task.
+type Settings struct {
+	Every    core.Duration `json:"Every"`
+	Interval core.Int      `json:"Interval"`
+	Period   string        `json:"Period"`
 }
 
+// TickerProvider: implements core.Provider interface
+type TickerProvider struct{}
+
 func NewTickerProvider() *TickerProvider {
 	return new(TickerProvider)
 }
@@ -43,60 +51,48 @@ func (tp *TickerProvider) Execute(j *runner.Job) error {
 	return nil
 }
 
-func (tp *TickerProvider) Register(fn func() *runner.Job) {
-	var err error
-	job := fn()
-
-	var task *runner.Task
-	for _, t := range job.Tasks {
-		if t.Provider == tp {
-			task = &t
-			break
-		}
-	}
-
-	err = json.Unmarshal(task.Properties, &tp.Settings)
+// Register starts a fresh run of the job on every tick, a slow run does not
+// delay the next one.
+func (tp *TickerProvider) Register(ctx context.Context, task *runner.Task, fn func() *runner.Job) {
+	var settings Settings
+	err := fn().Decode(task, &settings)
 	if err != nil {
 		log.Printf("Failed to unmarshal TickerProvider properties -> %v\n", err)
 		return
 	}
-	if len(tp.Settings.Interval) > 0 {
-		tp.interval, err = strconv.Atoi(tp.Settings.Interval)
-		if err != nil {
-			log.Printf("Failed to convert Interval to integer -> %v\n", err)
-			return
-		}
-	}
-	switch tp.Settings.Period {
+	var period time.Duration
+	switch settings.Period {
 	case "Second":
-		tp.period = int(time.Second)
+		period = time.Second
 	case "Millisecond":
-		tp.period = int(time.Millisecond)
+		period = time.Millisecond
 	case "Minute":
-		tp.period = int(time.Minute)
+		period = time.Minute
 	case "Hour":
-		tp.period = int(time.Hour)
+		period = time.Hour
 	case "Day":
-		tp.period = int(24 * time.Hour)
+		period = 24 * time.Hour
 	default:
-		tp.period = int(time.Second)
+		period = time.Second
+	}
+	interval := time.Duration(settings.Every)
+	if interval == 0 {
+		interval = time.Duration(settings.Interval) * period
 	}
-	if tp.interval == 0 {
-		log.Println("Interval must be set on TickerProvider")
+	if interval <= 0 {
+		log.Println("Every or Interval must be set on TickerProvider")
 		return
 	}
 
-	ticker := time.NewTicker(time.Duration(tp.interval * tp.period))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	func(C <-chan time.Time, j *runner.Job) {
-		for {
-			select {
-			case _, ok := <-C:
-				if !ok {
-					return
-				}
-				j.Run()
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn().Run()
 		}
-	}(ticker.C, job)
+	}
 }
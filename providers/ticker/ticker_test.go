@@ -0,0 +1,57 @@
+package ticker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kozical/taskengine/core"
+	"github.com/Kozical/taskengine/core/runner"
+	"github.com/Kozical/taskengine/core/runner/providertest"
+	"github.com/Kozical/taskengine/providers/localexec"
+)
+
+func TestOverlappingRuns(t *testing.T) {
+	const runs = 5
+	var h providertest.Harness
+	e := h.Register(context.Background(),
+		providertest.Task(NewTickerProvider(), "Tick", Settings{Every: core.Duration(10 * time.Millisecond)}),
+		providertest.Task(localexec.NewLocalExecProvider(), "Slow", localexec.Settings{File: "sh", Args: []core.String{"-c", "sleep 0.1; echo done"}}),
+	)
+	defer e.Close()
+
+	states := make(map[*runner.State]bool)
+	ids := make(map[int]bool)
+	var slow []runner.TaskRun
+	for i := 0; i < runs; i++ {
+		j := e.NextWithin(5 * time.Second)
+		if j == nil {
+			t.Fatalf("only %d of %d ticks recorded a job", i, runs)
+		}
+		if states[j.State] || ids[j.ID] {
+			t.Errorf("job %d shares its state or ID with another run", j.ID)
+		}
+		states[j.State], ids[j.ID] = true, true
+		if out, _ := j.Load("Slow.Stdout"); out != "done\n" {
+			t.Errorf("job %d: Stdout = %q", j.ID, out)
+		}
+		var titles []string
+		for _, run := range j.History() {
+			if run.Err != nil {
+				t.Errorf("job %d: %s: %v", j.ID, run.Task, run.Err)
+			}
+			if run.Task == "Slow" {
+				slow = append(slow, run)
+			}
+			titles = append(titles, run.Task)
+		}
+		if want := []string{"Tick", "Slow", providertest.DoneTask}; strings.Join(titles, ",") != strings.Join(want, ",") {
+			t.Errorf("job %d history = %q, want %q", j.ID, titles, want)
+		}
+	}
+	// a tick does not wait for the slow run before it
+	if len(slow) > 1 && !slow[1].Start.Before(slow[0].Start.Add(slow[0].Duration)) {
+		t.Error("runs of the job did not overlap")
+	}
+}